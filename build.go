@@ -1,188 +1,383 @@
 package firewall
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 )
 
 func (self *Firewall) Build(
 	settings *Settings,
 ) bool {
-	if !self.IsValid() {
-		log.Println("Build(): firewall invalid")
-		return false
-	}
-	if !self.buildPath(
+	if err := self.Generate(
 		settings,
-	) {
-		log.Println("Build(): failed to build path")
+	); err != nil {
+		log.Printf("Build(): %s\n", err)
 		return false
 	}
-	for name, server := range self.Servers {
-		if !self.buildServer(
-			settings,
-			name,
-			server,
-		) {
-			log.Printf("Build(): firewall.Server[%s] failed to build\n", name)
-			return false
-		}
-	}
 	return true
 }
 func (self *Firewall) BuildServer(
 	settings *Settings,
 	server string,
 ) bool {
-	if !self.IsValid() {
-		log.Printf("BuildServer(%s): firewall invalid\n", server)
+	if err := self.GenerateServer(
+		settings,
+		server,
+	); err != nil {
+		log.Printf("BuildServer(%s): %s\n", server, err)
 		return false
 	}
-	if _, ok := self.Servers[server]; !ok {
-		log.Printf("BuildServer(%s): Server not found\n", server)
-		return false
+	return true
+}
+
+// Generate builds every server, see `Build`
+// any error is returned as a Firewall_Error
+func (self *Firewall) Generate(
+	settings *Settings,
+) error {
+	if err := self.Check(); err != nil {
+		return err
 	}
-	if !self.buildPath(
-		settings,
-	) {
-		log.Printf("BuildServer(%s): failed to build path\n", server)
-		return false
+	// render every server before we touch the disk
+	// sort servers so any failure is deterministic
+	sorted := []string{}
+	for name, _ := range self.Servers {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	concurrency := 1
+	if settings.IsValid() &&
+		settings.BuildConcurrency > 1 {
+		concurrency = settings.BuildConcurrency
+	}
+	servers, err := self.renderServers(
+		sorted,
+		concurrency,
+	)
+	if err != nil {
+		return err
 	}
-	return self.buildServer(
+	return self.buildArtifacts(
 		settings,
-		server,
-		self.Servers[server],
+		servers,
 	)
 }
-func (self *Firewall) buildServer(
+
+// renderServers renders our sorted servers in memory
+// with a concurrency of 1 we stop at the first error
+// otherwise up to concurrency servers are rendered at the same time and every error is returned as Firewall_Errors
+// rendering only reads our config, the config must not be modified until we're done
+func (self *Firewall) renderServers(
+	sorted []string,
+	concurrency int,
+) (map[string]Firewall_Artifacts, error) {
+	servers := make(map[string]Firewall_Artifacts, len(sorted))
+	// our index is built once and shared by every server
+	index := self.Index()
+	if concurrency <= 1 {
+		for _, name := range sorted {
+			artifacts, err := self.renderServer(
+				name,
+				self.Servers[name],
+				index,
+			)
+			if err != nil {
+				return nil, err
+			}
+			servers[name] = artifacts
+		}
+		return servers, nil
+	}
+	// every worker writes only to its own index
+	artifacts := make([]Firewall_Artifacts, len(sorted))
+	errs := make([]error, len(sorted))
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < concurrency && w < len(sorted); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				artifacts[i], errs[i] = self.renderServer(
+					sorted[i],
+					self.Servers[sorted[i]],
+					index,
+				)
+			}
+		}()
+	}
+	for i, _ := range sorted {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	// aggregate our errors
+	all := Firewall_Errors{}
+	for i, name := range sorted {
+		if errs[i] != nil {
+			// wrapError always returns a Firewall_Error
+			var err *Firewall_Error
+			errors.As(wrapError(serverPath(name), errs[i]), &err)
+			all = append(all, err)
+			continue
+		}
+		servers[name] = artifacts[i]
+	}
+	if len(all) > 0 {
+		sort.Sort(all)
+		return nil, all
+	}
+	return servers, nil
+}
+
+// GenerateServer builds an individual server, see `BuildServer`
+// any error is returned as a Firewall_Error
+func (self *Firewall) GenerateServer(
 	settings *Settings,
-	name string,
-	server *Server,
-) bool {
-	// check our firewall
-	if !self.isFirewallValid(
-		name,
+	server string,
+) error {
+	artifacts, err := self.Render(
 		server,
-	) {
-		log.Printf("buildServer(%s): Firewall is Invalid\n", name)
-		return false
+	)
+	if err != nil {
+		return err
 	}
-	// build
-	if !self.buildHostname(
+	return self.buildArtifacts(
 		settings,
-		name,
-		server,
-	) {
-		log.Printf("buildServer(%s): Failed to Build Hostname\n", name)
-		return false
+		map[string]Firewall_Artifacts{
+			server: artifacts,
+		},
+	)
+}
+
+// buildArtifacts writes our rendered servers to a temporary folder next to our build folder
+// the build folder is only replaced once every file was written
+// if anything fails the previous build is left untouched
+func (self *Firewall) buildArtifacts(
+	settings *Settings,
+	servers map[string]Firewall_Artifacts,
+) error {
+	// create buildpath, including any parent folders
+	path := self.pathBase(settings)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return newError("build-path", "failed to create folder: %w", err)
 	}
-	if !self.buildHosts(
-		settings,
-		name,
-		server,
-	) {
-		log.Printf("buildServer(%s): Failed to Build Hosts\n", name)
-		return false
+	tmp, err := ioutil.TempDir(path, ".firewall-")
+	if err != nil {
+		return newError("build-path", "failed to create temporary folder: %w", err)
 	}
-	if !self.buildSSH(
-		settings,
-		name,
-		server,
-	) {
-		log.Printf("buildServer(%s): Failed to Build SSH\n", name)
-		return false
+	// tmp is our build once it has been swapped into place
+	swapped := false
+	defer func() {
+		if !swapped {
+			os.RemoveAll(tmp)
+		}
+	}()
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return newError("build-path", "failed to create temporary folder: %w", err)
 	}
-	fw := self.buildFirewall(
-		name,
-		server,
-	)
-	if fw == nil {
-		log.Printf("buildServer(%s): Failed to Build Firewall\n", name)
-		return false
+	// keep the files of our previous build unless their folder is to be removed
+	remove := map[string]bool{}
+	if settings.IsValid() {
+		remove[ARTIFACT_HOSTNAME] = settings.BuildRemoveFolderHostname
+		remove[ARTIFACT_HOSTS] = settings.BuildRemoveFolderHosts
+		remove[ARTIFACT_SSH] = settings.BuildRemoveFolderSSH
+		remove[ARTIFACT_FIREWALL] = settings.BuildRemoveFolderFirewall
+	}
+	if err := copyFolder(
+		self.pathfirewall(settings),
+		tmp,
+		remove,
+	); err != nil {
+		return newError("build-path", "failed to copy previous build: %w", err)
 	}
-	if self.FirewallType == FIREWALL_IPTABLES {
-		if !self.buildFirewallIPTables(
-			settings,
-			name,
-			server,
-			fw,
-		) {
-			log.Printf("buildServer(%s): Failed to Build Firewall: IPTables\n", name)
-			return false
+	for _, kind := range artifact_kinds {
+		if err := os.MkdirAll(filepath.Join(tmp, kind), 0755); err != nil {
+			return newError("build-path", "failed to create folder: %w", err)
 		}
-	} else {
-		log.Printf("buildServer(%s): Unknown Firewall Type\n", name)
 	}
-	return true
+	// sort servers so any failure is deterministic
+	sorted := []string{}
+	for name, _ := range servers {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		for _, kind := range artifact_kinds {
+			if err := buildFiles(
+				filepath.Join(tmp, kind),
+				servers[name][kind],
+			); err != nil {
+				return wrapError(serverPath(name), err)
+			}
+		}
+	}
+	// swap our new build into place
+	if err := swapFolder(
+		self.pathfirewall(settings),
+		tmp,
+	); err != nil {
+		return newError("build-path", "failed to replace previous build: %w", err)
+	}
+	swapped = true
+	return nil
 }
-func (self *Firewall) buildPath(
-	settings *Settings,
-) bool {
-	// create buildpath
-	path := self.pathBase(settings)
-	if path == "" {
-		log.Println("Save(): path was empty")
-		return false
+func buildFiles(
+	path string,
+	files map[string][]byte,
+) error {
+	// sort files so any failure is deterministic
+	sorted := []string{}
+	for file, _ := range files {
+		sorted = append(sorted, file)
 	}
-	// make paths
-	os.Mkdir(self.pathBase(settings), 0755)
-	// firewall
-	os.Mkdir(self.pathfirewall(settings), 0755)
-	// hostname
-	if settings.IsValid() && settings.BuildRemoveFolderHostname {
-		// remove old files
-		os.RemoveAll(self.pathHostname(settings))
-	}
-	os.Mkdir(self.pathHostname(settings), 0755)
-	// hosts
-	if settings.IsValid() && settings.BuildRemoveFolderHosts {
-		os.RemoveAll(self.pathHosts(settings))
-	}
-	os.Mkdir(self.pathHosts(settings), 0755)
-	// ssh
-	if settings.IsValid() && settings.BuildRemoveFolderSSH {
-		os.RemoveAll(self.pathSSH(settings))
-	}
-	os.Mkdir(self.pathSSH(settings), 0755)
-	// firewall
-	if settings.IsValid() && settings.BuildRemoveFolderFirewall {
-		os.RemoveAll(self.pathFirewall(settings))
-	}
-	os.Mkdir(self.pathFirewall(settings), 0755)
-	return true
+	sort.Strings(sorted)
+	for _, file := range sorted {
+		if err := ioutil.WriteFile(fmt.Sprintf("%s/%s", path, file), files[file], 0644); err != nil {
+			return fmt.Errorf("failed to write file: \"%s\": %w", file, err)
+		}
+	}
+	return nil
+}
+
+// copyFolder copies every file of our previous build into dst
+// top level folders within skip are not copied
+func copyFolder(
+	src string,
+	dst string,
+	skip map[string]bool,
+) error {
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		// there is no previous build
+		return nil
+	}
+	// src is usually a symlink to our previous build, which Walk doesn't follow
+	src, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(src, func(
+		path string,
+		info os.FileInfo,
+		err error,
+	) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if skip[rel] {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), bs, info.Mode().Perm())
+	})
+}
+
+// swapFolder replaces path with tmp
+// path is a symlink to our current build, a new symlink to tmp is renamed over it so path is replaced in a single rename
+// readers see either our previous build or our new build, and our previous build is removed once it has been replaced
+// if path is still a folder, ie: it was built before our symlinks, or symlinks aren't supported, ie: Windows without privileges
+// the folder is moved aside and our replacement is moved into place, path doesn't exist between these two renames
+func swapFolder(
+	path string,
+	tmp string,
+) error {
+	info, err := os.Lstat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	previous := ""
+	if info != nil &&
+		info.Mode()&os.ModeSymlink != 0 {
+		if previous, err = os.Readlink(path); err != nil {
+			return err
+		}
+		if !filepath.IsAbs(previous) {
+			previous = filepath.Join(filepath.Dir(path), previous)
+		}
+	}
+	// our target is relative, so our build path can be moved
+	link := tmp + "-link"
+	replacement := link
+	if err := os.Symlink(filepath.Base(tmp), link); err != nil {
+		replacement = tmp
+	}
+	// link no longer exists once it has been swapped into place
+	defer os.Remove(link)
+	old := ""
+	if info != nil &&
+		(previous == "" || replacement == tmp) {
+		// tmp is unique so this name is also unique
+		old = tmp + "-previous"
+		if err := os.Rename(path, old); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(replacement, path); err != nil {
+		if old != "" {
+			// restore our previous build
+			os.Rename(old, path)
+		}
+		return err
+	}
+	if old != "" {
+		os.RemoveAll(old)
+	}
+	// only remove a previous build that we created
+	if previous != "" &&
+		strings.HasPrefix(filepath.Base(previous), ".firewall-") {
+		os.RemoveAll(previous)
+	}
+	return nil
 }
 func (self *Firewall) pathBase(
 	settings *Settings,
 ) string {
-	if settings.IsValid() {
-		return filepath.Base(settings.BuildPath)
+	if settings.IsValid() &&
+		settings.BuildPath != "" {
+		// relative paths are relative to our working directory
+		return filepath.Clean(settings.BuildPath)
 	}
 	return "."
 }
 func (self *Firewall) pathfirewall(
 	settings *Settings,
 ) string {
-	return fmt.Sprintf("./%s/firewall", self.pathBase(settings))
+	return filepath.Join(self.pathBase(settings), "firewall")
 }
 func (self *Firewall) pathHostname(
 	settings *Settings,
 ) string {
-	return fmt.Sprintf("./%s/hostname", self.pathfirewall(settings))
+	return filepath.Join(self.pathfirewall(settings), ARTIFACT_HOSTNAME)
 }
 func (self *Firewall) pathHosts(
 	settings *Settings,
 ) string {
-	return fmt.Sprintf("./%s/hosts", self.pathfirewall(settings))
+	return filepath.Join(self.pathfirewall(settings), ARTIFACT_HOSTS)
 }
 func (self *Firewall) pathSSH(
 	settings *Settings,
 ) string {
-	return fmt.Sprintf("./%s/ssh", self.pathfirewall(settings))
+	return filepath.Join(self.pathfirewall(settings), ARTIFACT_SSH)
 }
 func (self *Firewall) pathFirewall(
 	settings *Settings,
 ) string {
-	return fmt.Sprintf("./%s/firewall", self.pathfirewall(settings))
+	return filepath.Join(self.pathfirewall(settings), ARTIFACT_FIREWALL)
 }
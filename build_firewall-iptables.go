@@ -3,297 +3,115 @@ package firewall
 import (
 	"bytes"
 	"fmt"
-	"log"
-	"os"
-	"sort"
+	"strings"
 )
 
-func (self *Firewall) buildFirewallIPTables(
-	settings *Settings,
-	name string,
-	server *Server,
-	fw *firewall,
-) bool {
-	file := fmt.Sprintf("%s/%s.iptables", self.pathFirewall(settings), name)
-	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+// the most ports that "-m multiport" can match
+const iptables_multiport_max = 15
+
+type renderer_iptables struct{}
+
+func (self renderer_iptables) Render(
+	fw *Firewall_Plan,
+) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	// iptables only supports IPv4, IPv6 networks are written to ip6tables
+	// `<server>.iptables` is always written, `<server>.ip6tables` is only written for dual stack servers
+	bs, err := renderIPTables(
+		fw.Filter(FAMILY_IPV4),
+	)
 	if err != nil {
-		log.Printf("buildFirewallIPTables(%s) failed to open iptables file: \"%s\"\n", name, err)
-		return false
+		return nil, err
+	}
+	files[fmt.Sprintf("%s.iptables", fw.ServerName)] = bs
+	if fw.HasFamily(FAMILY_IPV6) {
+		bs, err := renderIPTables(
+			fw.Filter(FAMILY_IPV6),
+		)
+		if err != nil {
+			return nil, err
+		}
+		files[fmt.Sprintf("%s.ip6tables", fw.ServerName)] = bs
 	}
-	defer f.Close()
+	return files, nil
+}
+func renderIPTables(
+	fw *Firewall_Plan,
+) ([]byte, error) {
 	buff := &bytes.Buffer{}
 	// our header
 	buff.WriteString("*filter\n\n")
-	buff.WriteString(fmt.Sprintf("### Server: \"%s\"\n", name))
-	buff.WriteString(fmt.Sprintf("### Hostname: \"%s\"\n", fw.Server.Hostname))
-	buff.WriteString("### IPs: [")
-	for i, ip := range fw.IPs {
-		if i > 0 {
-			buff.WriteString(", ")
-		}
-		buff.WriteString(ip)
+	writeFirewallHeader(
+		buff,
+		fw,
+	)
+	if err := writeFirewallRules(
+		buff,
+		fw,
+		formatIPTables,
+	); err != nil {
+		return nil, err
 	}
-	buff.WriteString("]\n\n")
-	// global rules before
-	if len(fw.GlobalRulesBefore) > 0 {
-		buff.WriteString("#######################\n")
-		buff.WriteString("# Global Rules Before #\n")
-		buff.WriteString("#######################\n")
-		for _, rule := range fw.GlobalRulesBefore {
-			// parse rule
-			if err := rule.Rule.ParseServer(buff, rule.Variables); err != nil {
-				log.Printf("buildFirewallIPTables(%s) failed to write global before rule: \"%s\"\n", name, err)
-				return false
-			}
-			buff.WriteString("\n")
-		}
-		buff.WriteString("\n")
+	// commit
+	buff.WriteString("### COMMIT !!!\n\n")
+	buff.WriteString("COMMIT\n")
+	return buff.Bytes(), nil
+}
+
+// formatIPTables writes a structured rule as an iptables-restore rule
+func formatIPTables(
+	rule *Firewall_Rule_Structured,
+) (string, error) {
+	parts := []string{"-A"}
+	switch rule.Direction {
+	case RULE_DIRECTION_IN:
+		parts = append(parts, "INPUT")
+	case RULE_DIRECTION_OUT:
+		parts = append(parts, "OUTPUT")
+	case RULE_DIRECTION_FORWARD:
+		parts = append(parts, "FORWARD")
 	}
-	// server rules before
-	if len(fw.ServerRulesBefore) > 0 {
-		buff.WriteString("#######################\n")
-		buff.WriteString("# Server Rules Before #\n")
-		buff.WriteString("#######################\n")
-		for _, rule := range fw.ServerRulesBefore {
-			// parse rule
-			if err := rule.Rule.ParseServer(buff, rule.Variables); err != nil {
-				log.Printf("buildFirewallIPTables(%s) failed to write server before rule: \"%s\"\n", name, err)
-				return false
-			}
-			buff.WriteString("\n")
+	if rule.Interface != "" {
+		if rule.Direction == RULE_DIRECTION_OUT {
+			parts = append(parts, "-o", rule.Interface)
+		} else {
+			parts = append(parts, "-i", rule.Interface)
 		}
-		buff.WriteString("\n")
 	}
-	if len(fw.Networks) > 0 {
-		// sort networks so they're deterministic
-		sorted := []string{}
-		for network_name, _ := range fw.Networks {
-			sorted = append(sorted, network_name)
-		}
-		sort.Strings(sorted)
-		nf := false // don't print networks if it's not needed
-		x := 0
-		for _, network_name := range sorted {
-			nsf := false // does this network have any services?
-			network := fw.Networks[network_name]
-			// passive services
-			if len(network.ServicesPassive) > 0 {
-				nsf = true
-			}
-			// services acquired by others
-			if len(network.ServicesAcquirable) > 0 {
-				nsf = true
-			}
-			// dependent services
-			if len(network.ServiceDependencies) > 0 {
-				nsf = true
-			}
-			// server firewall rules before
-			if len(network.RulesBefore) > 0 {
-				nsf = true
-			}
-			// server firewall rules after
-			if len(network.RulesAfter) > 0 {
-				nsf = true
-			}
-			if nsf && !nf {
-				// network actually exists
-				buff.WriteString("############\n")
-				buff.WriteString("# Networks #\n")
-				buff.WriteString("############\n")
-				nf = true
-			}
-			if nsf {
-				if x > 0 {
-					// extra network
-					buff.WriteString("\n")
-				}
-				x++
-				// network services actually exist
-				buff.WriteString(fmt.Sprintf("### Network: %s\n", network_name))
-				buff.WriteString(fmt.Sprintf("### IP: %s\n", network.Network.IP))
-				// server firewall rules before
-				if len(network.RulesBefore) > 0 {
-					buff.WriteString("########################\n")
-					buff.WriteString("# Network Rules Before #\n")
-					buff.WriteString("########################\n")
-					for _, rule := range network.RulesBefore {
-						// parse rule
-						if err := rule.Rule.ParseNetwork(buff, rule.Variables); err != nil {
-							log.Printf("buildFirewallIPTables(%s) failed to write network before \"%s\" rule: \"%s\"\n", name, network_name, err)
-							return false
-						}
-						buff.WriteString("\n")
-					}
-				}
-				// passive services
-				if len(network.ServicesPassive) > 0 {
-					buff.WriteString("######################\n")
-					buff.WriteString("## Passive Services ##\n")
-					buff.WriteString("######################\n")
-					// sort passive services so they're deterministic
-					sorted2 := []string{}
-					for service_name, _ := range network.ServicesPassive {
-						sorted2 = append(sorted2, service_name)
-					}
-					sort.Strings(sorted2)
-					for _, service_name := range sorted2 {
-						buff.WriteString(fmt.Sprintf("### Service: %s\n", service_name))
-						for _, rule := range network.ServicesPassive[service_name] {
-							// parse rule
-							if err := rule.Rule.ParseServicePassive(buff, rule.Variables); err != nil {
-								log.Printf("buildFirewallIPTables(%s) failed to write passive service \"%s\" rule: \"%s\"\n", name, service_name, err)
-								return false
-							}
-							buff.WriteString("\n")
-						}
-					}
-				}
-				// services acquired by others
-				if len(network.ServicesAcquirable) > 0 {
-					buff.WriteString("#########################\n")
-					buff.WriteString("## Acquirable Services ##\n")
-					buff.WriteString("#########################\n")
-					// sort acquirable services so they're deterministic
-					sorted2 := []string{}
-					for service_name, _ := range network.ServicesAcquirable {
-						sorted2 = append(sorted2, service_name)
-					}
-					sort.Strings(sorted2)
-					for _, service_name := range sorted2 {
-						buff.WriteString(fmt.Sprintf("### Service: %s\n", service_name))
-						// sort acquirable servers so they're deterministic
-						sorted3 := []string{}
-						for server2, _ := range network.ServicesAcquirable[service_name] {
-							sorted3 = append(sorted3, server2)
-						}
-						sort.Strings(sorted3)
-						for _, server2 := range sorted3 {
-							for i, rule := range network.ServicesAcquirable[service_name][server2] {
-								if i == 0 {
-									// only print server for the first rule
-									// all of the next variables will be the exact same
-									buff.WriteString(fmt.Sprintf("## Source Server: %s\n", rule.Variables.SourceServerName))
-									buff.WriteString(fmt.Sprintf("## Source Hostname: %s\n", rule.Variables.SourceServer.Hostname))
-									// source service is an optional object
-									if rule.Variables.SourceService != nil &&
-										rule.Variables.SourceService.Port > 0 {
-										buff.WriteString(fmt.Sprintf("## Source IP:Port: %s:%d\n", rule.Variables.SourceNetwork.IP, rule.Variables.SourceService.Port))
-									} else {
-										buff.WriteString(fmt.Sprintf("## Source IP: %s\n", rule.Variables.SourceNetwork.IP))
-									}
-								}
-								// parse rule
-								if err := rule.Rule.ParseServiceAcquirable(buff, rule.Variables); err != nil {
-									log.Printf("buildFirewallIPTables(%s) failed to write acquirable service \"%s\" rule: \"%s\"\n", name, service_name, err)
-									return false
-								}
-								buff.WriteString("\n")
-							}
-						}
-					}
-				}
-				// dependent services
-				if len(network.ServiceDependencies) > 0 {
-					buff.WriteString("#########################\n")
-					buff.WriteString("## Dependency Services ##\n")
-					buff.WriteString("#########################\n")
-					// sort acquirable services so they're deterministic
-					sorted2 := []string{}
-					for service_name, _ := range network.ServiceDependencies {
-						sorted2 = append(sorted2, service_name)
-					}
-					sort.Strings(sorted2)
-					for _, service_name := range sorted2 {
-						buff.WriteString(fmt.Sprintf("### Service: %s\n", service_name))
-						// sort acquirable servers so they're deterministic
-						sorted3 := []string{}
-						for server2, _ := range network.ServiceDependencies[service_name] {
-							sorted3 = append(sorted3, server2)
-						}
-						sort.Strings(sorted3)
-						for _, server2 := range sorted3 {
-							for i, rule := range network.ServiceDependencies[service_name][server2] {
-								if i == 0 {
-									// only print the first time
-									// all of the next variables will be the exact same
-									buff.WriteString(fmt.Sprintf("## Source Server: %s\n", rule.Variables.SourceServerName))
-									buff.WriteString(fmt.Sprintf("## Source Hostname: %s\n", rule.Variables.SourceServer.Hostname))
-									// source service is an optional object
-									if rule.Variables.SourceService != nil &&
-										rule.Variables.SourceService.Port > 0 {
-										buff.WriteString(fmt.Sprintf("## Source IP:Port: %s:%d\n", rule.Variables.SourceNetwork.IP, rule.Variables.SourceService.Port))
-									} else {
-										buff.WriteString(fmt.Sprintf("## Source IP: %s\n", rule.Variables.SourceNetwork.IP))
-									}
-								}
-								// parse rule
-								if err := rule.Rule.ParseServiceDependencies(buff, rule.Variables); err != nil {
-									log.Printf("buildFirewallIPTables(%s) failed to write dependent service \"%s\" rule: \"%s\"\n", name, service_name, err)
-									return false
-								}
-								buff.WriteString("\n")
-							}
-						}
-					}
-				}
-				// server firewall rules after
-				if len(network.RulesAfter) > 0 {
-					buff.WriteString("#######################\n")
-					buff.WriteString("# Network Rules After #\n")
-					buff.WriteString("#######################\n")
-					for _, rule := range network.RulesAfter {
-						// parse rule
-						if err := rule.Rule.ParseNetwork(buff, rule.Variables); err != nil {
-							log.Printf("buildFirewallIPTables(%s) failed to write network after \"%s\" rule: \"%s\"\n", name, network_name, err)
-							return false
-						}
-						buff.WriteString("\n")
-					}
-				}
-			}
-		}
-		if nf {
-			// divider newline
-			buff.WriteString("\n")
-		}
+	if rule.Protocol == "icmp" &&
+		rule.family() == FAMILY_IPV6 {
+		// ip6tables only matches ICMPv6
+		parts = append(parts, "-p", "ipv6-icmp")
+	} else if rule.Protocol != "" {
+		parts = append(parts, "-p", rule.Protocol)
 	}
-	// server rules before
-	if len(fw.ServerRulesAfter) > 0 {
-		buff.WriteString("######################\n")
-		buff.WriteString("# Server Rules After #\n")
-		buff.WriteString("######################\n")
-		for _, rule := range fw.ServerRulesAfter {
-			// parse rule
-			if err := rule.Rule.ParseServer(buff, rule.Variables); err != nil {
-				log.Printf("buildFirewallIPTables(%s) failed to write server after rule: \"%s\"\n", name, err)
-				return false
-			}
-			buff.WriteString("\n")
-		}
-		buff.WriteString("\n")
+	if rule.Source != "" {
+		parts = append(parts, "--src", rule.Source)
 	}
-	// global rules before
-	if len(fw.GlobalRulesAfter) > 0 {
-		buff.WriteString("######################\n")
-		buff.WriteString("# Global Rules After #\n")
-		buff.WriteString("######################\n")
-		for _, rule := range fw.GlobalRulesAfter {
-			// parse rule
-			if err := rule.Rule.ParseServer(buff, rule.Variables); err != nil {
-				log.Printf("buildFirewallIPTables(%s) failed to write global after rule: \"%s\"\n", name, err)
-				return false
+	if rule.Destination != "" {
+		parts = append(parts, "--dst", rule.Destination)
+	}
+	if len(rule.Ports) == 1 {
+		parts = append(parts, "--dport", strings.Replace(rule.Ports[0], "-", ":", 1))
+	} else if len(rule.Ports) > 1 {
+		ports := []string{}
+		// multiport supports at most 15 ports, a range counts as two
+		count := 0
+		for _, port := range rule.Ports {
+			ports = append(ports, strings.Replace(port, "-", ":", 1))
+			count++
+			if strings.Contains(port, "-") {
+				count++
 			}
-			buff.WriteString("\n")
 		}
-		buff.WriteString("\n")
+		if count > iptables_multiport_max {
+			return "", fmt.Errorf("Firewall_Rule.Ports: iptables multiport supports at most %d ports, a range counts as two: %d", iptables_multiport_max, count)
+		}
+		parts = append(parts, "-m", "multiport", "--dports", strings.Join(ports, ","))
 	}
-	// commit
-	buff.WriteString("### COMMIT !!!\n\n")
-	buff.WriteString("COMMIT\n")
-	if _, err := buff.WriteTo(f); err != nil {
-		log.Printf("buildFirewallIPTables(%s) failed to write iptables file: \"%s\"\n", name, err)
-		return false
+	if len(rule.State) > 0 {
+		parts = append(parts, "-m", "state", "--state", strings.ToUpper(strings.Join(rule.State, ",")))
 	}
-	return true
+	parts = append(parts, "-j", strings.ToUpper(rule.Action))
+	return strings.Join(parts, " "), nil
 }
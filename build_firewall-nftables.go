@@ -0,0 +1,93 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+type renderer_nftables struct{}
+
+func (self renderer_nftables) Render(
+	fw *Firewall_Plan,
+) (map[string][]byte, error) {
+	buff := &bytes.Buffer{}
+	// our header
+	// the ruleset is loaded with `nft -f`
+	// rules are written as nft commands, ie: "add rule inet filter input tcp dport 22 accept"
+	// structured rules are always added to the input, output or forward chain of "table inet filter"
+	buff.WriteString("#!/usr/sbin/nft -f\n\n")
+	writeFirewallHeader(
+		buff,
+		fw,
+	)
+	if err := writeFirewallRules(
+		buff,
+		fw,
+		formatNFTables,
+	); err != nil {
+		return nil, err
+	}
+	// nft applies the whole file as a single transaction, there's nothing to commit
+	buff.WriteString("### END !!!\n")
+	return map[string][]byte{
+		fmt.Sprintf("%s.nft", fw.ServerName): buff.Bytes(),
+	}, nil
+}
+
+// formatNFTables writes a structured rule as an nft command
+func formatNFTables(
+	rule *Firewall_Rule_Structured,
+) (string, error) {
+	parts := []string{"add rule inet filter"}
+	switch rule.Direction {
+	case RULE_DIRECTION_IN:
+		parts = append(parts, "input")
+	case RULE_DIRECTION_OUT:
+		parts = append(parts, "output")
+	case RULE_DIRECTION_FORWARD:
+		parts = append(parts, "forward")
+	}
+	if rule.Interface != "" {
+		if rule.Direction == RULE_DIRECTION_OUT {
+			parts = append(parts, "oifname", fmt.Sprintf("%q", rule.Interface))
+		} else {
+			parts = append(parts, "iifname", fmt.Sprintf("%q", rule.Interface))
+		}
+	}
+	if rule.Source != "" {
+		parts = append(parts, nftAddressFamily(rule.Source), "saddr", rule.Source)
+	}
+	if rule.Destination != "" {
+		parts = append(parts, nftAddressFamily(rule.Destination), "daddr", rule.Destination)
+	}
+	if len(rule.Ports) == 1 {
+		parts = append(parts, rule.Protocol, "dport", rule.Ports[0])
+	} else if len(rule.Ports) > 1 {
+		parts = append(parts, rule.Protocol, "dport", fmt.Sprintf("{ %s }", strings.Join(rule.Ports, ", ")))
+	} else if rule.Protocol == "icmp" &&
+		rule.family() == FAMILY_IPV6 {
+		// icmp only matches ICMPv4
+		parts = append(parts, "meta l4proto", "icmpv6")
+	} else if rule.Protocol != "" {
+		parts = append(parts, "meta l4proto", rule.Protocol)
+	}
+	if len(rule.State) > 0 {
+		parts = append(parts, "ct state", strings.Join(rule.State, ","))
+	}
+	parts = append(parts, rule.Action)
+	return strings.Join(parts, " "), nil
+}
+
+// nftAddressFamily returns "ip6" for IPv6 addresses and CIDRs, otherwise "ip"
+func nftAddressFamily(
+	address string,
+) string {
+	if i := strings.Index(address, "/"); i > -1 {
+		address = address[:i]
+	}
+	if ipFamily(address) == FAMILY_IPV6 {
+		return "ip6"
+	}
+	return "ip"
+}
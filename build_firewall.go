@@ -4,55 +4,76 @@ import (
 	"sort"
 )
 
-type firewall struct {
-	ServerName        string
-	Server            *Server
+// Firewall_Plan is the parsed relations of a single server
+// every firewall type is rendered from this same plan
+type Firewall_Plan struct {
+	ServerName string
+	Server     *Server
+	// IP Family of this plan
+	// 0 includes every network, see `Firewall_Plan.Filter`
+	Family int
+	// unique and sorted
 	IPs               []string
-	GlobalRulesBefore []*firewall_rule_server
-	ServerRulesBefore []*firewall_rule_server
-	Networks          map[string]*firewall_network
-	ServerRulesAfter  []*firewall_rule_server
-	GlobalRulesAfter  []*firewall_rule_server
+	GlobalRulesBefore []*Firewall_Plan_Rule_Server
+	ServerRulesBefore []*Firewall_Plan_Rule_Server
+	// [NetworkName]Network
+	Networks         map[string]*Firewall_Plan_Network
+	ServerRulesAfter []*Firewall_Plan_Rule_Server
+	GlobalRulesAfter []*Firewall_Plan_Rule_Server
 }
-type firewall_rule_server struct {
+type Firewall_Plan_Rule_Server struct {
+	// location of our rule in our config
+	Path      string
 	Rule      *Firewall_Rule
 	Variables *Firewall_Variables_Server
 }
-type firewall_rule_network struct {
+type Firewall_Plan_Rule_Network struct {
+	// location of our rule in our config
+	Path      string
 	Rule      *Firewall_Rule
 	Variables *Firewall_Variables_Network
 }
-type firewall_rule_service_passive struct {
+type Firewall_Plan_Rule_Service_Passive struct {
+	// location of our rule in our config
+	Path      string
 	Rule      *Firewall_Rule
 	Variables *Firewall_Variables_Service_Passive
 }
-type firewall_rule_service_acquirable struct {
+type Firewall_Plan_Rule_Service_Acquirable struct {
+	// location of our rule in our config
+	Path      string
 	Rule      *Firewall_Rule
 	Variables *Firewall_Variables_Service_Acquirable
 }
-type firewall_rule_service_dependencies struct {
+type Firewall_Plan_Rule_Service_Dependencies struct {
+	// location of our rule in our config
+	Path      string
 	Rule      *Firewall_Rule
 	Variables *Firewall_Variables_Service_Dependencies
 }
-type firewall_network struct {
-	Network             *Network
-	ServicesPassive     map[string][]*firewall_rule_service_passive
-	ServicesAcquirable  map[string]map[string][]*firewall_rule_service_acquirable
-	ServiceDependencies map[string]map[string][]*firewall_rule_service_dependencies
-	RulesBefore         []*firewall_rule_network
-	RulesAfter          []*firewall_rule_network
+type Firewall_Plan_Network struct {
+	Network *Network
+	// [ServiceName][]Rule
+	ServicesPassive map[string][]*Firewall_Plan_Rule_Service_Passive
+	// [ServiceName][SourceServerName][]Rule
+	ServicesAcquirable map[string]map[string][]*Firewall_Plan_Rule_Service_Acquirable
+	// [ServiceName][SourceServerName][]Rule
+	ServiceDependencies map[string]map[string][]*Firewall_Plan_Rule_Service_Dependencies
+	RulesBefore         []*Firewall_Plan_Rule_Network
+	RulesAfter          []*Firewall_Plan_Rule_Network
 }
 
 func (self *Firewall) buildFirewall(
 	name string,
 	server *Server,
-) *firewall {
+	index *Firewall_Index,
+) *Firewall_Plan {
 	// we're building an object to return for templating
 	// this will allow us to reuse the same parsed object for different firewalls
-	f := &firewall{
+	f := &Firewall_Plan{
 		ServerName: name,
 		Server:     server,
-		Networks:   make(map[string]*firewall_network),
+		Networks:   make(map[string]*Firewall_Plan_Network),
 	}
 	// append only unique IPs
 	unique := make(map[string]struct{})
@@ -71,25 +92,28 @@ func (self *Firewall) buildFirewall(
 		ServerName: name,
 		Server:     server,
 		Firewall:   self,
+		Vars:       mergeVars(self.Vars, server.Vars),
 	}
 	// global rules before
-	for _, rule := range self.FirewallRulesBefore {
+	for i, rule := range self.FirewallRulesBefore {
 		// append rule
 		f.GlobalRulesBefore = append(
 			f.GlobalRulesBefore,
-			&firewall_rule_server{
+			&Firewall_Plan_Rule_Server{
 				Rule:      rule,
+				Path:      indexPath("firewall-rules-before", i),
 				Variables: server_vars,
 			},
 		)
 	}
 	// server rules before
-	for _, rule := range server.FirewallRulesBefore {
+	for i, rule := range server.FirewallRulesBefore {
 		// append rule
 		f.ServerRulesBefore = append(
 			f.ServerRulesBefore,
-			&firewall_rule_server{
+			&Firewall_Plan_Rule_Server{
 				Rule:      rule,
+				Path:      indexPath(joinPath(serverPath(name), "firewall-before"), i),
 				Variables: server_vars,
 			},
 		)
@@ -98,11 +122,11 @@ func (self *Firewall) buildFirewall(
 	// we don't need to sort services here!!!
 	for network_name, network := range server.Networks {
 		// network doesn't exist yet, so we have to create an object for it
-		f.Networks[network_name] = &firewall_network{
+		f.Networks[network_name] = &Firewall_Plan_Network{
 			Network:             network,
-			ServicesPassive:     make(map[string][]*firewall_rule_service_passive),
-			ServicesAcquirable:  make(map[string]map[string][]*firewall_rule_service_acquirable),
-			ServiceDependencies: make(map[string]map[string][]*firewall_rule_service_dependencies),
+			ServicesPassive:     make(map[string][]*Firewall_Plan_Rule_Service_Passive),
+			ServicesAcquirable:  make(map[string]map[string][]*Firewall_Plan_Rule_Service_Acquirable),
+			ServiceDependencies: make(map[string]map[string][]*Firewall_Plan_Rule_Service_Dependencies),
 		}
 		network_vars := &Firewall_Variables_Network{
 			ServerName:  name,
@@ -110,25 +134,29 @@ func (self *Firewall) buildFirewall(
 			NetworkName: network_name,
 			Network:     network,
 			Firewall:    self,
+			Family:      network.Family(),
+			Vars:        mergeVars(self.Vars, server.Vars, network.Vars),
 		}
 		// network rules before
-		for _, rule := range network.FirewallRulesBefore {
+		for i, rule := range network.FirewallRulesBefore {
 			// append rule
 			f.Networks[network_name].RulesBefore = append(
 				f.Networks[network_name].RulesBefore,
-				&firewall_rule_network{
+				&Firewall_Plan_Rule_Network{
 					Rule:      rule,
+					Path:      indexPath(joinPath(serverPath(name), "networks", network_name, "firewall-rules-before"), i),
 					Variables: network_vars,
 				},
 			)
 		}
 		// network rules after
-		for _, rule := range network.FirewallRulesAfter {
+		for i, rule := range network.FirewallRulesAfter {
 			// append rule
 			f.Networks[network_name].RulesAfter = append(
 				f.Networks[network_name].RulesAfter,
-				&firewall_rule_network{
+				&Firewall_Plan_Rule_Network{
 					Rule:      rule,
+					Path:      indexPath(joinPath(serverPath(name), "networks", network_name, "firewall-rules-after"), i),
 					Variables: network_vars,
 				},
 			)
@@ -136,12 +164,13 @@ func (self *Firewall) buildFirewall(
 		// load passive services
 		for service_name, service := range network.ServicesPassive {
 			// set passive service
-			for _, rule := range service.FirewallRules {
+			for i, rule := range service.FirewallRules {
 				// append passive rule
 				f.Networks[network_name].ServicesPassive[service_name] = append(
 					f.Networks[network_name].ServicesPassive[service_name],
-					&firewall_rule_service_passive{
+					&Firewall_Plan_Rule_Service_Passive{
 						Rule: rule,
+						Path: indexPath(joinPath(serverPath(name), "networks", network_name, "services-passive", service_name, "rules"), i),
 						Variables: &Firewall_Variables_Service_Passive{
 							ServerName:  name,
 							Server:      server,
@@ -150,6 +179,8 @@ func (self *Firewall) buildFirewall(
 							ServiceName: service_name,
 							Service:     service,
 							Firewall:    self,
+							Family:      network.Family(),
+							Vars:        mergeVars(self.Vars, server.Vars, network.Vars, service.Vars),
 						},
 					},
 				)
@@ -158,103 +189,62 @@ func (self *Firewall) buildFirewall(
 	}
 	// services acquired by others
 	// we don't need to sort services here!!!
-	// check external dependencies that rely on us
-	// loop all of our available networks
-	for network_name, network := range server.Networks {
-		// loop all of the available servers
-		// check if any servers have acquired services from this network
-		for server_name2, server2 := range self.Servers {
-			// loop all of that servers available networks
-			for network_name2, network2 := range server2.Networks {
-				// check if their network depends on our server
-				// [ServerName][NetworkName][ServiceName]Service
-				if networks2, ok := network2.ServiceDependencies[name]; ok {
-					// check if they depend on our servers network
-					// [NetworkName][ServiceName]Service
-					if services2, ok := networks2[network_name]; ok {
-						// check if they depend on our networks services
-						// [ServiceName]Service
-						for service_name2, service2 := range services2 {
-							// check if they depend an individual service
-							if service, ok := network.ServicesAcquirable[service_name2]; ok {
-								// they depend on this service
-								// make sure a network object has been created
-								if _, ok := f.Networks[network_name]; !ok {
-									// create network
-									f.Networks[network_name] = &firewall_network{
-										Network:             network,
-										ServicesPassive:     make(map[string][]*firewall_rule_service_passive),
-										ServicesAcquirable:  make(map[string]map[string][]*firewall_rule_service_acquirable),
-										ServiceDependencies: make(map[string]map[string][]*firewall_rule_service_dependencies),
-									}
-									network_vars := &Firewall_Variables_Network{
-										ServerName:  name,
-										Server:      server,
-										NetworkName: network_name,
-										Network:     network,
-										Firewall:    self,
-									}
-									// network rules before
-									for _, rule := range network.FirewallRulesBefore {
-										// append rule
-										f.Networks[network_name].RulesBefore = append(
-											f.Networks[network_name].RulesBefore,
-											&firewall_rule_network{
-												Rule:      rule,
-												Variables: network_vars,
-											},
-										)
-									}
-									// network rules after
-									for _, rule := range network.FirewallRulesAfter {
-										// append rule
-										f.Networks[network_name].RulesAfter = append(
-											f.Networks[network_name].RulesAfter,
-											&firewall_rule_network{
-												Rule:      rule,
-												Variables: network_vars,
-											},
-										)
-									}
-									// load acquired services
-								}
-								// set acquired service
-								for _, rule := range service.FirewallRules {
-									// append dependent rule
-									if _, ok := f.Networks[network_name].ServicesAcquirable[service_name2]; !ok {
-										// service server doesnt exist yet
-										f.Networks[network_name].ServicesAcquirable[service_name2] = make(map[string][]*firewall_rule_service_acquirable)
-									}
-									f.Networks[network_name].ServicesAcquirable[service_name2][server_name2] = append(
-										f.Networks[network_name].ServicesAcquirable[service_name2][server_name2],
-										&firewall_rule_service_acquirable{
-											Rule: rule,
-											// source is always the imported dependency
-											// destination is the importer
-											Variables: &Firewall_Variables_Service_Acquirable{
-												// source service_name and destination service_name will always be the same
-												ServiceName:       service_name2,
-												SourceServerName:  server_name2,
-												SourceServer:      server2,
-												SourceNetworkName: network_name2,
-												SourceNetwork:     network2,
-												// source service and destination service can be different
-												// source service is an optional object
-												SourceService:          service2,
-												DestinationServerName:  name,
-												DestinationServer:      server,
-												DestinationNetworkName: network_name,
-												DestinationNetwork:     network,
-												DestinationService:     service,
-												Firewall:               self,
-											},
-										},
-									)
-								}
-							}
-						}
-					}
+	// our index contains every network that depends on us, sorted
+	for _, consumer := range index.Consumers(name) {
+		// check if they depend on our servers network
+		network_name := consumer.ProviderNetworkName
+		network, ok := server.Networks[network_name]
+		if !ok {
+			continue
+		}
+		server_name2 := consumer.ServerName
+		server2 := consumer.Server
+		network_name2 := consumer.NetworkName
+		network2 := consumer.Network
+		// check if they depend on our networks services
+		// [ServiceName]Service
+		for service_name2, service2 := range consumer.Services {
+			// check if they depend an individual service
+			service, ok := network.ServicesAcquirable[service_name2]
+			if !ok {
+				continue
+			}
+			// they depend on this service
+			// set acquired service
+			for i, rule := range service.FirewallRules {
+				// append dependent rule
+				if _, ok := f.Networks[network_name].ServicesAcquirable[service_name2]; !ok {
+					// service server doesnt exist yet
+					f.Networks[network_name].ServicesAcquirable[service_name2] = make(map[string][]*Firewall_Plan_Rule_Service_Acquirable)
 				}
+				f.Networks[network_name].ServicesAcquirable[service_name2][server_name2] = append(
+					f.Networks[network_name].ServicesAcquirable[service_name2][server_name2],
+					&Firewall_Plan_Rule_Service_Acquirable{
+						Rule: rule,
+						Path: indexPath(joinPath(serverPath(name), "networks", network_name, "services-acquirable", service_name2, "rules"), i),
+						// source is always the imported dependency
+						// destination is the importer
+						Variables: &Firewall_Variables_Service_Acquirable{
+							// source service_name and destination service_name will always be the same
+							ServiceName:       service_name2,
+							SourceServerName:  server_name2,
+							SourceServer:      server2,
+							SourceNetworkName: network_name2,
+							SourceNetwork:     network2,
+							// source service and destination service can be different
+							// source service is an optional object
+							SourceService:          service2,
+							DestinationServerName:  name,
+							DestinationServer:      server,
+							DestinationNetworkName: network_name,
+							DestinationNetwork:     network,
+							DestinationService:     service,
+							Firewall:               self,
+							Family:                 network.Family(),
+							Vars:                   mergeVars(self.Vars, server.Vars, network.Vars, service.Vars),
+						},
+					},
+				)
 			}
 		}
 	}
@@ -273,15 +263,15 @@ func (self *Firewall) buildFirewall(
 					// service is our local service object, not the remote service, thus it is service not service2
 					// service is optional, rules are only triggered if service is non nil
 					if service != nil {
-						for _, rule := range service.FirewallRules {
+						for i, rule := range service.FirewallRules {
 							// make sure a network object has been created
 							if _, ok := f.Networks[network_name]; !ok {
 								// create network
-								f.Networks[network_name] = &firewall_network{
+								f.Networks[network_name] = &Firewall_Plan_Network{
 									Network:             network,
-									ServicesPassive:     make(map[string][]*firewall_rule_service_passive),
-									ServicesAcquirable:  make(map[string]map[string][]*firewall_rule_service_acquirable),
-									ServiceDependencies: make(map[string]map[string][]*firewall_rule_service_dependencies),
+									ServicesPassive:     make(map[string][]*Firewall_Plan_Rule_Service_Passive),
+									ServicesAcquirable:  make(map[string]map[string][]*Firewall_Plan_Rule_Service_Acquirable),
+									ServiceDependencies: make(map[string]map[string][]*Firewall_Plan_Rule_Service_Dependencies),
 								}
 								network_vars := &Firewall_Variables_Network{
 									ServerName:  name,
@@ -289,25 +279,29 @@ func (self *Firewall) buildFirewall(
 									NetworkName: network_name,
 									Network:     network,
 									Firewall:    self,
+									Family:      network.Family(),
+									Vars:        mergeVars(self.Vars, server.Vars, network.Vars),
 								}
 								// network rules before
-								for _, rule := range network.FirewallRulesBefore {
+								for i, rule := range network.FirewallRulesBefore {
 									// append rule
 									f.Networks[network_name].RulesBefore = append(
 										f.Networks[network_name].RulesBefore,
-										&firewall_rule_network{
+										&Firewall_Plan_Rule_Network{
 											Rule:      rule,
+											Path:      indexPath(joinPath(serverPath(name), "networks", network_name, "firewall-rules-before"), i),
 											Variables: network_vars,
 										},
 									)
 								}
 								// network rules after
-								for _, rule := range network.FirewallRulesAfter {
+								for i, rule := range network.FirewallRulesAfter {
 									// append rule
 									f.Networks[network_name].RulesAfter = append(
 										f.Networks[network_name].RulesAfter,
-										&firewall_rule_network{
+										&Firewall_Plan_Rule_Network{
 											Rule:      rule,
+											Path:      indexPath(joinPath(serverPath(name), "networks", network_name, "firewall-rules-after"), i),
 											Variables: network_vars,
 										},
 									)
@@ -316,11 +310,12 @@ func (self *Firewall) buildFirewall(
 							}
 							if _, ok := f.Networks[network_name].ServiceDependencies[service_name2]; !ok {
 								// service server doesnt exist yet
-								f.Networks[network_name].ServiceDependencies[service_name2] = make(map[string][]*firewall_rule_service_dependencies)
+								f.Networks[network_name].ServiceDependencies[service_name2] = make(map[string][]*Firewall_Plan_Rule_Service_Dependencies)
 							}
 							f.Networks[network_name].ServiceDependencies[service_name2][server_name2] = append(
 								f.Networks[network_name].ServiceDependencies[service_name2][server_name2],
-								&firewall_rule_service_dependencies{
+								&Firewall_Plan_Rule_Service_Dependencies{
+									Path: indexPath(joinPath(serverPath(name), "networks", network_name, "service-dependencies", server_name2, network_name2, service_name2, "rules"), i),
 									Rule: rule,
 									// source is always the imported dependency
 									// destination is the importer
@@ -342,6 +337,8 @@ func (self *Firewall) buildFirewall(
 										DestinationNetwork:     network,
 										DestinationService:     service,
 										Firewall:               self,
+										Family:                 network.Family(),
+										Vars:                   mergeVars(self.Vars, server.Vars, network.Vars, service.Vars),
 									},
 								},
 							)
@@ -352,26 +349,95 @@ func (self *Firewall) buildFirewall(
 		}
 	}
 	// server rules after
-	for _, rule := range server.FirewallRulesAfter {
+	for i, rule := range server.FirewallRulesAfter {
 		// append rule
 		f.ServerRulesAfter = append(
 			f.ServerRulesAfter,
-			&firewall_rule_server{
+			&Firewall_Plan_Rule_Server{
 				Rule:      rule,
+				Path:      indexPath(joinPath(serverPath(name), "firewall-after"), i),
 				Variables: server_vars,
 			},
 		)
 	}
 	// global rules after
-	for _, rule := range self.FirewallRulesAfter {
+	for i, rule := range self.FirewallRulesAfter {
 		// append rule
 		f.GlobalRulesAfter = append(
 			f.GlobalRulesAfter,
-			&firewall_rule_server{
+			&Firewall_Plan_Rule_Server{
 				Rule:      rule,
+				Path:      indexPath("firewall-rules-after", i),
 				Variables: server_vars,
 			},
 		)
 	}
 	return f
 }
+
+// HasFamily returns true if any of our networks belong to the IP family
+func (self *Firewall_Plan) HasFamily(
+	family int,
+) bool {
+	for _, network := range self.Networks {
+		if network.Network.Family() == family {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns a copy of our plan that only includes the networks of an IP family
+// acquired and dependent services are routed by their destination network, which is always our network
+// Global and Server rules are included in every family, their variables are copied so templates know which family they're rendered for
+func (self *Firewall_Plan) Filter(
+	family int,
+) *Firewall_Plan {
+	f := &Firewall_Plan{
+		ServerName: self.ServerName,
+		Server:     self.Server,
+		Family:     family,
+		Networks:   make(map[string]*Firewall_Plan_Network),
+	}
+	for _, ip := range self.IPs {
+		if ipFamily(ip) == family {
+			f.IPs = append(f.IPs, ip)
+		}
+	}
+	// server variables are shared by every server rule, copy them once
+	server_vars := make(map[*Firewall_Variables_Server]*Firewall_Variables_Server)
+	filter := func(
+		rules []*Firewall_Plan_Rule_Server,
+	) []*Firewall_Plan_Rule_Server {
+		var filtered []*Firewall_Plan_Rule_Server
+		for _, rule := range rules {
+			vars, ok := server_vars[rule.Variables]
+			if !ok {
+				copied := *rule.Variables
+				copied.Family = family
+				vars = &copied
+				server_vars[rule.Variables] = vars
+			}
+			filtered = append(
+				filtered,
+				&Firewall_Plan_Rule_Server{
+					Path:      rule.Path,
+					Rule:      rule.Rule,
+					Variables: vars,
+				},
+			)
+		}
+		return filtered
+	}
+	f.GlobalRulesBefore = filter(self.GlobalRulesBefore)
+	f.ServerRulesBefore = filter(self.ServerRulesBefore)
+	f.ServerRulesAfter = filter(self.ServerRulesAfter)
+	f.GlobalRulesAfter = filter(self.GlobalRulesAfter)
+	// network and service variables already know their family
+	for network_name, network := range self.Networks {
+		if network.Network.Family() == family {
+			f.Networks[network_name] = network
+		}
+	}
+	return f
+}
@@ -3,8 +3,6 @@ package firewall
 import (
 	"bytes"
 	"fmt"
-	"log"
-	"os"
 	"sort"
 )
 
@@ -12,18 +10,10 @@ const (
 	hosts_maxlen = 1000
 )
 
-func (self *Firewall) buildHosts(
-	settings *Settings,
+func (self *Firewall) renderHosts(
 	name string,
 	server *Server,
-) bool {
-	file := fmt.Sprintf("%s/%s.hosts", self.pathHosts(settings), name)
-	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		log.Printf("buildHosts(%s) failed to open hosts file: \"%s\"\n", name, err)
-		return false
-	}
-	defer f.Close()
+) (map[string][]byte, error) {
 	buff := &bytes.Buffer{}
 	// hosts our header
 	buff.WriteString(fmt.Sprintf("### Server: \"%s\"\n", name))
@@ -91,19 +81,16 @@ func (self *Firewall) buildHosts(
 		sort.Strings(sorted)
 		for _, server_name := range sorted {
 			if name == server_name {
-				log.Printf("buildHosts(%s) Acquired Server Name is the same as our Server Name: \"%s\"\n", name, server_name)
-				return false
+				return nil, newError(joinPath(serverPath(name), "hosts-dependencies", server_name), "Acquired Server Name is the same as our Server Name")
 			}
 			s, ok := self.Servers[server_name]
 			if !ok {
-				log.Printf("buildHosts(%s) Acquired Server not found: \"%s\"\n", name, server_name)
-				return false
+				return nil, newError(joinPath(serverPath(name), "hosts-dependencies", server_name), "Acquired Server not found: \"%s\"", server_name)
 			}
-			for _, network := range server.HostsDependencies[server_name] {
+			for i, network := range server.HostsDependencies[server_name] {
 				n, ok := s.Networks[network]
 				if !ok {
-					log.Printf("buildHosts(%s) Acquired Server Network not found: \"%s\" -> \"%s\"\n", name, server_name, network)
-					return false
+					return nil, newError(indexPath(joinPath(serverPath(name), "hosts-dependencies", server_name), i), "Acquired Server Network not found: \"%s\" -> \"%s\"", server_name, network)
 				}
 				buff.WriteString(fmt.Sprintf("## Server: \"%s\" Network: \"%s\"\n", server_name, network))
 				// print hosts
@@ -120,11 +107,9 @@ func (self *Firewall) buildHosts(
 		buff.WriteString(server.HostsAfter)
 		buff.WriteString("\n\n")
 	}
-	if _, err := buff.WriteTo(f); err != nil {
-		log.Printf("buildHosts(%s) failed to write hosts file: \"%s\"\n", name, err)
-		return false
-	}
-	return true
+	return map[string][]byte{
+		fmt.Sprintf("%s.hosts", name): buff.Bytes(),
+	}, nil
 }
 func printHosts(
 	buff *bytes.Buffer,
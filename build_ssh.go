@@ -3,46 +3,29 @@ package firewall
 import (
 	"bytes"
 	"fmt"
-	"log"
-	"os"
 )
 
-func (self *Firewall) buildSSH(
-	settings *Settings,
+func (self *Firewall) renderSSH(
 	name string,
 	server *Server,
-) bool {
-	// loop and create a shell script for each ssh connection
+) map[string][]byte {
+	// create a shell script for each ssh connection
 	// we don't have to worry about being deterministic because each is its own file
+	files := make(map[string][]byte)
 	for service, ssh := range server.SSH {
-		file := fmt.Sprintf("%s/%s-%s.sh", self.pathSSH(settings), name, service)
-		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-		if err != nil {
-			log.Printf("buildSSH(%s) failed to open ssh file: \"%s\"\n", name, err)
-			return false
-		}
-		// putting file in its own function so we can easily defer file closures in a loop
-		if !buildSSH(
-			f,
+		files[fmt.Sprintf("%s-%s.sh", name, service)] = renderSSH(
 			name,
-			server,
 			service,
 			ssh,
-		) {
-			// failed
-			return false
-		}
+		)
 	}
-	return true
+	return files
 }
-func buildSSH(
-	f *os.File,
+func renderSSH(
 	name string,
-	server *Server,
 	service string,
 	ssh *SSH,
-) bool {
-	defer f.Close()
+) []byte {
 	buff := &bytes.Buffer{}
 	buff.WriteString("#!/bin/bash\n")
 	buff.WriteString(fmt.Sprintf("### Server: \"%s\"\n", name))
@@ -100,9 +83,5 @@ func buildSSH(
 		buff.WriteString(fmt.Sprintf(" -p %d", ssh.Port))
 	}
 	buff.WriteString("\n")
-	if _, err := buff.WriteTo(f); err != nil {
-		log.Printf("buildSSH(%s) failed to write ssh file: \"%s\"\n", name, err)
-		return false
-	}
-	return true
+	return buff.Bytes()
 }
@@ -1,9 +1,14 @@
 package firewall
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/sabey/unittest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -41,3 +46,204 @@ func TestBuild(t *testing.T) {
 	unittest.Equals(t, string(bs), "{}")
 	fmt.Printf("Firewall: \"%s\"\n", bs)
 }
+func TestBuildAtomic(t *testing.T) {
+	fmt.Println("TestBuildAtomic")
+	settings := &Settings{
+		BuildPath:                 "unittest",
+		BuildRemoveFolderFirewall: true,
+	}
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers: map[string]*Server{
+			"Atomic": &Server{
+				Hostname: "atomic",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.1.1",
+					},
+				},
+			},
+		},
+	}
+	unittest.Equals(t, fw.Build(settings), true)
+	first, err := ioutil.ReadFile(fmt.Sprintf("%s/Atomic.iptables", fw.pathFirewall(settings)))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, len(first) > 0, true)
+
+	// a failing server must leave our previous build untouched
+	fw.Servers["Atomic"].Hostname = "atomic2"
+	fw.Servers["Broken"] = &Server{
+		Hostname: "broken",
+		Networks: map[string]*Network{
+			"lan": &Network{
+				IP: "10.0.1.2",
+				FirewallRulesBefore: []*Firewall_Rule{
+					&Firewall_Rule{
+						Rule: "{{.Missing}}",
+					},
+				},
+			},
+		},
+	}
+	unittest.Equals(t, fw.Build(settings), false)
+	second, err := ioutil.ReadFile(fmt.Sprintf("%s/Atomic.iptables", fw.pathFirewall(settings)))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, bytes.Equal(first, second), true)
+	_, err = os.Stat(fmt.Sprintf("%s/Broken.iptables", fw.pathFirewall(settings)))
+	unittest.Equals(t, os.IsNotExist(err), true)
+	// our build is a symlink to the only build folder, no temporary folders are left behind
+	builds, err := filepath.Glob(fmt.Sprintf("%s/.firewall-*", fw.pathBase(settings)))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, len(builds), 1)
+	target, err := os.Readlink(fw.pathfirewall(settings))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, target, filepath.Base(builds[0]))
+
+	// once fixed our build is replaced
+	delete(fw.Servers, "Broken")
+	unittest.Equals(t, fw.Build(settings), true)
+	second, err = ioutil.ReadFile(fmt.Sprintf("%s/Atomic.iptables", fw.pathFirewall(settings)))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, bytes.Contains(second, []byte("### Hostname: \"atomic2\"")), true)
+	// our previous build is removed
+	_, err = os.Stat(builds[0])
+	unittest.Equals(t, os.IsNotExist(err), true)
+	builds, err = filepath.Glob(fmt.Sprintf("%s/.firewall-*", fw.pathBase(settings)))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, len(builds), 1)
+
+	// a folder built before our symlinks is replaced by a symlink
+	// our build path is relative to our working directory
+	tmp := "firewall-build-atomic"
+	defer os.RemoveAll(tmp)
+	settings.BuildPath = tmp
+	unittest.IsNil(t, os.MkdirAll(filepath.Join(tmp, "firewall", "hosts"), 0755))
+	unittest.IsNil(t, ioutil.WriteFile(filepath.Join(tmp, "firewall", "hosts", "Old.hosts"), []byte("old\n"), 0644))
+	unittest.Equals(t, fw.Build(settings), true)
+	info, err := os.Lstat(filepath.Join(tmp, "firewall"))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, info.Mode()&os.ModeSymlink != 0, true)
+	// the files of our previous build are kept
+	bs, err := ioutil.ReadFile(filepath.Join(tmp, "firewall", "hosts", "Old.hosts"))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, string(bs), "old\n")
+	builds, err = filepath.Glob(filepath.Join(tmp, ".firewall-*"))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, len(builds), 1)
+}
+func TestBuildPath(t *testing.T) {
+	fmt.Println("TestBuildPath")
+	tmp, err := ioutil.TempDir("", "firewall-build-path-")
+	unittest.IsNil(t, err)
+	defer os.RemoveAll(tmp)
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers: map[string]*Server{
+			"Nested": &Server{
+				Hostname: "nested",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.2.1",
+					},
+				},
+			},
+		},
+	}
+	// absolute and nested paths are kept as is
+	settings := &Settings{
+		BuildPath: filepath.Join(tmp, "out", "prod"),
+	}
+	unittest.Equals(t, fw.pathFirewall(settings), filepath.Join(tmp, "out", "prod", "firewall", "firewall"))
+	unittest.Equals(t, fw.Build(settings), true)
+	_, err = os.Stat(filepath.Join(tmp, "out", "prod", "firewall", "hostname", "Nested.hostname"))
+	unittest.IsNil(t, err)
+	// an empty path is our working directory
+	unittest.Equals(t, fw.pathBase(&Settings{}), ".")
+	unittest.Equals(t, fw.pathBase(nil), ".")
+
+	// folders that can't be created are reported
+	unittest.IsNil(t, ioutil.WriteFile(filepath.Join(tmp, "file"), []byte{}, 0644))
+	settings.BuildPath = filepath.Join(tmp, "file", "prod")
+	err = fw.Generate(settings)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, strings.HasPrefix(err.Error(), "build-path: failed to create folder: "), true)
+}
+func TestBuildConcurrency(t *testing.T) {
+	fmt.Println("TestBuildConcurrency")
+	tmp, err := ioutil.TempDir("", "firewall-build-concurrency-")
+	unittest.IsNil(t, err)
+	defer os.RemoveAll(tmp)
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers:      make(map[string]*Server),
+	}
+	for i := 0; i < 20; i++ {
+		server := &Server{
+			Hostname: fmt.Sprintf("server%d", i),
+			Networks: map[string]*Network{
+				"lan": &Network{
+					IP: fmt.Sprintf("10.0.3.%d", i+1),
+					ServicesAcquirable: map[string]*Service{
+						"mysql": &Service{
+							Port: 3306,
+							FirewallRules: []*Firewall_Rule{
+								&Firewall_Rule{
+									Rule: "-A INPUT -p tcp --src {{.SourceNetwork.IP}} --dport {{.DestinationService.Port}} -j ACCEPT",
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		if i > 0 {
+			// depend on the previous server
+			server.Networks["lan"].ServiceDependencies = map[string]map[string]map[string]*Service{
+				fmt.Sprintf("Server%d", i-1): map[string]map[string]*Service{
+					"lan": map[string]*Service{
+						"mysql": nil,
+					},
+				},
+			}
+		}
+		fw.Servers[fmt.Sprintf("Server%d", i)] = server
+	}
+	sequential := &Settings{
+		BuildPath: filepath.Join(tmp, "sequential"),
+	}
+	unittest.IsNil(t, fw.Generate(sequential))
+	concurrent := &Settings{
+		BuildPath:        filepath.Join(tmp, "concurrent"),
+		BuildConcurrency: 4,
+	}
+	unittest.IsNil(t, fw.Generate(concurrent))
+	// output is identical
+	files, err := filepath.Glob(filepath.Join(fw.pathFirewall(sequential), "*"))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, len(files), 20)
+	for _, file := range files {
+		first, err := ioutil.ReadFile(file)
+		unittest.IsNil(t, err)
+		second, err := ioutil.ReadFile(filepath.Join(fw.pathFirewall(concurrent), filepath.Base(file)))
+		unittest.IsNil(t, err)
+		unittest.Equals(t, bytes.Equal(first, second), true)
+	}
+
+	// every failed server is reported
+	for _, name := range []string{"Server3", "Server12"} {
+		fw.Servers[name].Networks["lan"].FirewallRulesBefore = []*Firewall_Rule{
+			&Firewall_Rule{
+				Rule: "{{.Missing}}",
+			},
+		}
+	}
+	err = fw.Generate(concurrent)
+	errs, ok := err.(Firewall_Errors)
+	unittest.Equals(t, ok, true)
+	unittest.Equals(t, len(errs), 2)
+	unittest.Equals(t, errs[0].Path, "servers.Server12.networks.lan.firewall-rules-before[0]")
+	unittest.Equals(t, errs[1].Path, "servers.Server3.networks.lan.firewall-rules-before[0]")
+	// one at a time stops at the first failure
+	_, ok = fw.Generate(sequential).(*Firewall_Error)
+	unittest.Equals(t, ok, true)
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/sabey/firewall"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+)
+
+// exit codes
+const (
+	EXIT_OK = iota
+	// the firewall is invalid or failed to build
+	EXIT_FAILED
+	// unknown command or flags
+	EXIT_USAGE
+	// settings or firewall couldn't be read or decoded
+	EXIT_INPUT
+)
+
+const usage = `Usage: firewall <command> [flags]
+
+Commands:
+  build     generate every server, or only -server, into settings.BuildPath/firewall
+  validate  report every problem in the firewall, including port conflicts
+  lint      report unused config as warnings, warnings don't fail
+  render    print the generated files of -server to stdout
+  query     print who can reach what and the config path that grants it:
+              -from and -to, optionally -port, print if -from can reach -to on -port
+              -server, -network and -service print the consumers of a service
+              -server prints the services acquired by the server
+  dot       print the service dependency graph of the firewall to stdout as Graphviz DOT
+  schema    print the JSON Schema of -schema to stdout
+
+Flags:
+`
+
+type input struct {
+	SettingsInput string
+	SettingsFile  string
+	FirewallInput string
+	FirewallFile  string
+	FirewallDir   string
+	Server        string
+	Schema        string
+	From          string
+	To            string
+	Port          uint
+	Network       string
+	Service       string
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+func run(
+	args []string,
+	stdout io.Writer,
+	stderr io.Writer,
+) int {
+	if len(args) == 0 {
+		printUsage(stderr, nil)
+		return EXIT_USAGE
+	}
+	command := args[0]
+	in := &input{}
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&in.SettingsInput, "settings-input", "", "Raw JSON input")
+	flags.StringVar(&in.SettingsFile, "settings-file", "", "Location of JSON, YAML or TOML file, picked by extension")
+	flags.StringVar(&in.FirewallInput, "firewall-input", "", "Raw JSON input")
+	flags.StringVar(&in.FirewallFile, "firewall-file", "", "Location of JSON, YAML or TOML file, picked by extension")
+	flags.StringVar(&in.FirewallDir, "firewall-dir", "", "Location of a fleet directory, a root firewall file and one file per server in servers/")
+	flags.StringVar(&in.Server, "server", "", "If specified, only the server is generated, otherwise all servers are generated")
+	flags.StringVar(&in.From, "from", "", "The source server of query")
+	flags.StringVar(&in.To, "to", "", "The destination server of query")
+	flags.UintVar(&in.Port, "port", 0, "The destination port of query, a service is matched by the ports its rules open, or by 0 if it has none")
+	flags.StringVar(&in.Network, "network", "", "The network of -server for query")
+	flags.StringVar(&in.Service, "service", "", "The service of -network for query")
+	flags.StringVar(&in.Schema, "schema", "firewall", "The JSON Schema printed by schema, \"firewall\" or \"settings\"")
+	flags.Usage = func() {
+		printUsage(stderr, flags)
+	}
+	switch command {
+	case "build", "validate", "lint", "render", "query", "dot", "schema":
+	case "help", "-h", "-help", "--help":
+		printUsage(stdout, flags)
+		return EXIT_OK
+	default:
+		fmt.Fprintf(stderr, "unknown command: \"%s\"\n", command)
+		printUsage(stderr, flags)
+		return EXIT_USAGE
+	}
+	if err := flags.Parse(args[1:]); err != nil {
+		return EXIT_USAGE
+	}
+	if flags.NArg() > 0 {
+		fmt.Fprintf(stderr, "unexpected arguments: %v\n", flags.Args())
+		return EXIT_USAGE
+	}
+	if command == "schema" {
+		// schema doesn't read any input
+		return schema(stdout, stderr, in.Schema)
+	}
+	settings, fw, err := in.load()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_INPUT
+	}
+	switch command {
+	case "build":
+		return build(stderr, settings, fw, in.Server)
+	case "validate":
+		return validate(stdout, stderr, fw)
+	case "lint":
+		return lint(stdout, fw)
+	case "render":
+		return render(stdout, stderr, fw, in.Server)
+	case "query":
+		return query(stdout, stderr, fw, in)
+	case "dot":
+		return dot(stdout, stderr, fw)
+	}
+	return EXIT_USAGE
+}
+func printUsage(
+	w io.Writer,
+	flags *flag.FlagSet,
+) {
+	fmt.Fprint(w, usage)
+	if flags != nil {
+		flags.SetOutput(w)
+		flags.PrintDefaults()
+	}
+}
+func (self *input) load() (
+	*firewall.Settings,
+	*firewall.Firewall,
+	error,
+) {
+	// settings are optional
+	var settings *firewall.Settings
+	if bs, format, err := readInput("settings", self.SettingsInput, self.SettingsFile); err != nil {
+		return nil, nil, err
+	} else if bs != nil {
+		settings = &firewall.Settings{}
+		if err := firewall.Unmarshal(format, bs, settings); err != nil {
+			return nil, nil, fmt.Errorf("settings: %w", err)
+		}
+	}
+	if self.FirewallDir != "" {
+		if self.FirewallInput != "" || self.FirewallFile != "" {
+			return nil, nil, fmt.Errorf("firewall: -firewall-dir can't be combined with -firewall-input or -firewall-file")
+		}
+		fw, err := firewall.LoadFirewallDir(self.FirewallDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("firewall: %w", err)
+		}
+		return settings, fw, nil
+	}
+	bs, format, err := readInput("firewall", self.FirewallInput, self.FirewallFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bs == nil {
+		return nil, nil, fmt.Errorf("firewall: -firewall-input, -firewall-file or -firewall-dir is required")
+	}
+	fw := &firewall.Firewall{}
+	if err := firewall.Unmarshal(format, bs, fw); err != nil {
+		return nil, nil, fmt.Errorf("firewall: %w", err)
+	}
+	return settings, fw, nil
+}
+
+// readInput returns nil if neither raw input or a file were given
+// raw input is always JSON, the format of a file is picked by its extension
+func readInput(
+	name string,
+	raw string,
+	file string,
+) ([]byte, string, error) {
+	if raw != "" && file != "" {
+		return nil, "", fmt.Errorf("%s: -%s-input and -%s-file can't both be set", name, name, name)
+	}
+	if raw != "" {
+		return []byte(raw), firewall.FORMAT_JSON, nil
+	}
+	if file != "" {
+		format, err := firewall.FormatByPath(file)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", name, err)
+		}
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s: %w", name, err)
+		}
+		return bs, format, nil
+	}
+	return nil, "", nil
+}
+func build(
+	stderr io.Writer,
+	settings *firewall.Settings,
+	fw *firewall.Firewall,
+	server string,
+) int {
+	var err error
+	if server == "" {
+		err = fw.Generate(settings)
+	} else {
+		err = fw.GenerateServer(settings, server)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_FAILED
+	}
+	return EXIT_OK
+}
+func validate(
+	stdout io.Writer,
+	stderr io.Writer,
+	fw *firewall.Firewall,
+) int {
+	errs := fw.Validate()
+	// services that open overlapping ports can be built, but they're never intended
+	errs = append(errs, fw.PortConflicts()...)
+	if len(errs) > 0 {
+		sort.Sort(errs)
+		fmt.Fprintln(stderr, errs)
+		return EXIT_FAILED
+	}
+	fmt.Fprintln(stdout, "firewall is valid")
+	return EXIT_OK
+}
+func lint(
+	stdout io.Writer,
+	fw *firewall.Firewall,
+) int {
+	warnings := fw.Lint()
+	for _, warning := range warnings {
+		fmt.Fprintf(stdout, "warning: %s\n", warning)
+	}
+	if len(warnings) == 0 {
+		fmt.Fprintln(stdout, "no warnings")
+	}
+	return EXIT_OK
+}
+func render(
+	stdout io.Writer,
+	stderr io.Writer,
+	fw *firewall.Firewall,
+	server string,
+) int {
+	if server == "" {
+		fmt.Fprintln(stderr, "render: -server is required")
+		return EXIT_USAGE
+	}
+	artifacts, err := fw.Render(
+		server,
+	)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_FAILED
+	}
+	// print every file sorted by kind and filename
+	files := []string{}
+	for kind, _ := range artifacts {
+		for file, _ := range artifacts[kind] {
+			files = append(files, path.Join(kind, file))
+		}
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		fmt.Fprintf(stdout, "==> %s <==\n", file)
+		stdout.Write(artifacts[path.Dir(file)][path.Base(file)])
+		fmt.Fprintln(stdout)
+	}
+	return EXIT_OK
+}
+func query(
+	stdout io.Writer,
+	stderr io.Writer,
+	fw *firewall.Firewall,
+	in *input,
+) int {
+	var access []*firewall.Firewall_Access
+	switch {
+	case in.From != "" && in.To != "" && in.Server == "":
+		if in.Port > math.MaxUint16 {
+			fmt.Fprintf(stderr, "query: -port %d is out of range\n", in.Port)
+			return EXIT_USAGE
+		}
+		grant := fw.CanReach(in.From, in.To, uint16(in.Port))
+		if grant == nil {
+			fmt.Fprintf(stdout, "%s can't reach %s on port %d\n", in.From, in.To, in.Port)
+			return EXIT_FAILED
+		}
+		fmt.Fprintf(stdout, "%s can reach %s on port %d\n", in.From, in.To, in.Port)
+		access = []*firewall.Firewall_Access{grant}
+	case in.Server != "" && in.Network != "" && in.Service != "" && in.From == "" && in.To == "":
+		access = fw.Consumers(in.Server, in.Network, in.Service)
+	case in.Server != "" && in.Network == "" && in.Service == "" && in.From == "" && in.To == "":
+		access = fw.Providers(in.Server)
+	default:
+		fmt.Fprintln(stderr, "query: requires -from and -to, -server, -network and -service, or only -server")
+		return EXIT_USAGE
+	}
+	for _, a := range access {
+		fmt.Fprintf(stdout, "%s\t%s\n", a, a.Path)
+	}
+	return EXIT_OK
+}
+func dot(
+	stdout io.Writer,
+	stderr io.Writer,
+	fw *firewall.Firewall,
+) int {
+	bs, err := fw.DOT()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_FAILED
+	}
+	stdout.Write(bs)
+	return EXIT_OK
+}
+func schema(
+	stdout io.Writer,
+	stderr io.Writer,
+	name string,
+) int {
+	var v interface{}
+	switch name {
+	case "firewall":
+		v = &firewall.Firewall{}
+	case "settings":
+		v = &firewall.Settings{}
+	default:
+		fmt.Fprintf(stderr, "schema: unknown schema: \"%s\"\n", name)
+		return EXIT_USAGE
+	}
+	bs, err := firewall.Schema(v)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return EXIT_FAILED
+	}
+	stdout.Write(bs)
+	return EXIT_OK
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sabey/unittest"
+	"strings"
+	"testing"
+)
+
+const test_firewall = `{
+  "firewall-type": 1,
+  "servers": {
+    "solo": {
+      "hostname": "solo",
+      "networks": {
+        "lan": {
+          "ip": "192.168.1.1"
+        }
+      }
+    }
+  }
+}`
+
+func TestRun(t *testing.T) {
+	fmt.Println("TestRun")
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	unittest.Equals(t, run(nil, stdout, stderr), EXIT_USAGE)
+	unittest.Equals(t, run([]string{"unknown"}, stdout, stderr), EXIT_USAGE)
+	unittest.Equals(t, run([]string{"build", "-unknown"}, stdout, stderr), EXIT_USAGE)
+	unittest.Equals(t, run([]string{"build"}, stdout, stderr), EXIT_INPUT)
+	unittest.Equals(t, run([]string{"build", "-firewall-input", "{"}, stdout, stderr), EXIT_INPUT)
+	unittest.Equals(t, run([]string{"validate", "-firewall-input", "{}"}, stdout, stderr), EXIT_FAILED)
+	unittest.Equals(t, run([]string{"render", "-firewall-input", test_firewall}, stdout, stderr), EXIT_USAGE)
+	unittest.Equals(t, run([]string{"render", "-firewall-input", test_firewall, "-server", "missing"}, stdout, stderr), EXIT_FAILED)
+
+	// files are read by their extension
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"validate", "-firewall-file", "../../unittest/config/firewall.yaml"}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, stdout.String(), "firewall is valid\n")
+	unittest.Equals(t, run([]string{"validate", "-firewall-file", "../../unittest/config/firewall.ini"}, stdout, stderr), EXIT_INPUT)
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"validate", "-firewall-dir", "../../unittest/config/fleet"}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, stdout.String(), "firewall is valid\n")
+	unittest.Equals(t, run([]string{"validate", "-firewall-dir", "../../unittest/config/fleet", "-firewall-input", test_firewall}, stdout, stderr), EXIT_INPUT)
+
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"validate", "-firewall-input", test_firewall}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, stdout.String(), "firewall is valid\n")
+
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"render", "-firewall-input", test_firewall, "-server", "solo"}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, strings.Contains(stdout.String(), "==> firewall/solo.iptables <==\n*filter\n"), true)
+	unittest.Equals(t, strings.Contains(stdout.String(), "==> hostname/solo.hostname <==\nsolo\n"), true)
+
+	// port conflicts are reported by validate
+	conflict := `{"firewall-type": 1, "servers": {"solo": {"hostname": "solo", "networks": {"lan": {"ip": "192.168.1.1",
+		"services-passive": {"ssh": {"port": 22, "rules": [{"rule": "# ssh"}]}},
+		"services-acquirable": {"ssh": {"port": 22, "rules": [{"rule": "# ssh"}]}}}}}}}`
+	stderr.Reset()
+	unittest.Equals(t, run([]string{"validate", "-firewall-input", conflict}, stdout, stderr), EXIT_FAILED)
+	unittest.Equals(t, stderr.String(), "servers.solo.networks.lan.services-acquirable.ssh: port 22 on 192.168.1.1 conflicts with servers.solo.networks.lan.services-passive.ssh\n")
+
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"lint", "-firewall-file", "../../unittest/config/firewall.json"}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, strings.HasPrefix(stdout.String(), "warning: servers.MyPC.networks.lan.vars.in-interface: not read by any rule\n"), true)
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"lint", "-firewall-input", test_firewall}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, stdout.String(), "no warnings\n")
+
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"query", "-firewall-file", "../../unittest/config/firewall.json", "-from", "MyPC", "-to", "MediaServer", "-port", "3306"}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, stdout.String(), "MyPC can reach MediaServer on port 3306\nMyPC/lan -> MediaServer/lan/mysql:3306\tservers.MyPC.networks.lan.service-dependencies.MediaServer.lan.mysql\n")
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"query", "-firewall-file", "../../unittest/config/firewall.json", "-from", "MediaServer", "-to", "MyPC", "-port", "22"}, stdout, stderr), EXIT_FAILED)
+	unittest.Equals(t, stdout.String(), "MediaServer can't reach MyPC on port 22\n")
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"query", "-firewall-file", "../../unittest/config/firewall.json", "-server", "MediaServer", "-network", "lan", "-service", "ssh"}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, stdout.String(), "any -> MediaServer/lan/ssh:22\tservers.MediaServer.networks.lan.services-passive.ssh\n")
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"query", "-firewall-file", "../../unittest/config/firewall.json", "-server", "MyPC"}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, stdout.String(), "MyPC/lan -> MediaServer/lan/mysql:3306\tservers.MyPC.networks.lan.service-dependencies.MediaServer.lan.mysql\n")
+	unittest.Equals(t, run([]string{"query", "-firewall-file", "../../unittest/config/firewall.json", "-from", "MyPC"}, stdout, stderr), EXIT_USAGE)
+	unittest.Equals(t, run([]string{"query", "-firewall-file", "../../unittest/config/firewall.json", "-from", "MyPC", "-to", "MediaServer", "-port", "70000"}, stdout, stderr), EXIT_USAGE)
+
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"dot", "-firewall-input", test_firewall}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, strings.Contains(stdout.String(), "\"network/solo/lan\" [shape=ellipse, label=\"lan\\n192.168.1.1\"];\n"), true)
+	unittest.Equals(t, run([]string{"dot", "-firewall-input", "{}"}, stdout, stderr), EXIT_FAILED)
+
+	// schema doesn't read any input
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"schema"}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, strings.Contains(stdout.String(), `"title": "Firewall"`), true)
+	stdout.Reset()
+	unittest.Equals(t, run([]string{"schema", "-schema", "settings"}, stdout, stderr), EXIT_OK)
+	unittest.Equals(t, strings.Contains(stdout.String(), `"title": "Settings"`), true)
+	unittest.Equals(t, run([]string{"schema", "-schema", "server"}, stdout, stderr), EXIT_USAGE)
+}
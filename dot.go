@@ -0,0 +1,139 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the node that passive services are open to, labelled "any"
+// every other node ID starts with its kind and contains a "/", so no name can be our ID, see `dotID`
+const dot_any = "__any__"
+
+// "/" separates the names of a node ID, so it's escaped within a name
+var dot_escape = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// DOT returns the topology of our servers as a Graphviz DOT graph
+// every server is a cluster of its networks and services
+// passive services are drawn as a box with an edge from "any", acquirable services are drawn as a rounded box
+// service dependencies are drawn from the acquiring network to the acquired service, labelled with the service name and port
+// hosts dependencies are drawn as a dashed edge from a server to the network it includes
+// the edges are read from the same index as our builds, so our firewall must be valid, see `Validate`
+func (self *Firewall) DOT() ([]byte, error) {
+	if err := self.Validate().err(); err != nil {
+		return nil, err
+	}
+	index := self.Index()
+	sorted := []string{}
+	for name, _ := range self.Servers {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	w := &bytes.Buffer{}
+	fmt.Fprintln(w, "digraph firewall {")
+	fmt.Fprintln(w, "\tcompound=true;")
+	fmt.Fprintln(w, "\trankdir=LR;")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+	fmt.Fprintf(w, "\t%s [shape=circle, label=\"any\"];\n", dotQuote(dot_any))
+	// nodes
+	for _, name := range sorted {
+		server := self.Servers[name]
+		fmt.Fprintf(w, "\tsubgraph %s {\n", dotQuote(dotCluster(name)))
+		fmt.Fprintf(w, "\t\tlabel=%s;\n", dotQuote(name))
+		// our server is an invisible node, so edges can start at our cluster
+		fmt.Fprintf(w, "\t\t%s [shape=point, style=invis];\n", dotID("server", name))
+		for _, network_name := range sortedNetworks(server) {
+			network := server.Networks[network_name]
+			id := dotID("network", name, network_name)
+			fmt.Fprintf(w, "\t\t%s [shape=ellipse, label=%s];\n", id, dotQuote(network_name+"\n"+network.IP))
+			for _, service_name := range sortedServices(network.ServicesPassive) {
+				fmt.Fprintf(w, "\t\t%s [label=%s];\n", dotID("passive", name, network_name, service_name), dotQuote(service_name))
+				fmt.Fprintf(w, "\t\t%s -> %s [arrowhead=none, style=dotted];\n", id, dotID("passive", name, network_name, service_name))
+			}
+			for _, service_name := range sortedServices(network.ServicesAcquirable) {
+				fmt.Fprintf(w, "\t\t%s [style=rounded, label=%s];\n", dotID("acquirable", name, network_name, service_name), dotQuote(service_name))
+				fmt.Fprintf(w, "\t\t%s -> %s [arrowhead=none, style=dotted];\n", id, dotID("acquirable", name, network_name, service_name))
+			}
+		}
+		fmt.Fprintln(w, "\t}")
+	}
+	// passive services
+	for _, name := range sorted {
+		server := self.Servers[name]
+		for _, network_name := range sortedNetworks(server) {
+			services := server.Networks[network_name].ServicesPassive
+			for _, service_name := range sortedServices(services) {
+				fmt.Fprintf(w, "\t%s -> %s [label=%s];\n", dotQuote(dot_any), dotID("passive", name, network_name, service_name), dotLabel(service_name, services[service_name]))
+			}
+		}
+	}
+	// service dependencies, by the server that provides them
+	for _, name := range sorted {
+		server := self.Servers[name]
+		for _, consumer := range index.Consumers(name) {
+			services := server.Networks[consumer.ProviderNetworkName].ServicesAcquirable
+			for _, service_name := range sortedServices(consumer.Services) {
+				fmt.Fprintf(w, "\t%s -> %s [label=%s];\n", dotID("network", consumer.ServerName, consumer.NetworkName), dotID("acquirable", name, consumer.ProviderNetworkName, service_name), dotLabel(service_name, services[service_name]))
+			}
+		}
+	}
+	// hosts dependencies
+	for _, name := range sorted {
+		server := self.Servers[name]
+		sorted2 := []string{}
+		for name2, _ := range server.HostsDependencies {
+			sorted2 = append(sorted2, name2)
+		}
+		sort.Strings(sorted2)
+		for _, name2 := range sorted2 {
+			for _, network_name := range server.HostsDependencies[name2] {
+				fmt.Fprintf(w, "\t%s -> %s [ltail=%s, style=dashed, label=\"hosts\"];\n", dotID("server", name), dotID("network", name2, network_name), dotQuote(dotCluster(name)))
+			}
+		}
+	}
+	fmt.Fprintln(w, "}")
+	return w.Bytes(), nil
+}
+
+// dotID returns the quoted ID of a node of kind, ie: "acquirable/MyPC/lan/mysql"
+// a "/" within a name is escaped, so the IDs of different names can't be the same
+func dotID(
+	kind string,
+	names ...string,
+) string {
+	id := kind
+	for _, name := range names {
+		id += "/" + dot_escape.Replace(name)
+	}
+	return dotQuote(id)
+}
+func dotCluster(
+	server string,
+) string {
+	return "cluster_" + server
+}
+
+// dotLabel returns the quoted label of an edge to service, ie: "mysql:3306"
+// a service without a port is labelled with only its name
+func dotLabel(
+	name string,
+	service *Service,
+) string {
+	if service == nil ||
+		service.Port == 0 {
+		return dotQuote(name)
+	}
+	return dotQuote(fmt.Sprintf("%s:%d", name, service.Port))
+}
+
+// dotQuote returns s as a quoted DOT string
+func dotQuote(
+	s string,
+) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
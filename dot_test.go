@@ -0,0 +1,110 @@
+package firewall
+
+import (
+	"fmt"
+	"github.com/sabey/unittest"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDOT(t *testing.T) {
+	fmt.Println("TestDOT")
+	fw, err := LoadFirewall("unittest/config/firewall.json")
+	unittest.IsNil(t, err)
+	bs, err := fw.DOT()
+	unittest.IsNil(t, err)
+	expected, err := ioutil.ReadFile("unittest/results/graph/firewall.dot")
+	unittest.IsNil(t, err)
+	unittest.Equals(t, string(bs), string(expected))
+
+	// names are quoted
+	fw = &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers: map[string]*Server{
+			`Web "1"`: &Server{
+				Hostname: "web",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.0.1",
+						ServicesPassive: map[string]*Service{
+							"ping": &Service{
+								FirewallRules: []*Firewall_Rule{
+									&Firewall_Rule{
+										Protocol: "icmp",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	bs, err = fw.DOT()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, strings.Contains(string(bs), "\tsubgraph \"cluster_Web \\\"1\\\"\" {\n"), true)
+	// a service without a port is labelled with its name
+	unittest.Equals(t, strings.Contains(string(bs), "\t\"__any__\" -> \"passive/Web \\\"1\\\"/lan/ping\" [label=\"ping\"];\n"), true)
+
+	// names containing a "/" or named like our any node don't share a node
+	fw = &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers: map[string]*Server{
+			"a/b": &Server{
+				Hostname: "ab",
+				Networks: map[string]*Network{
+					"c": &Network{
+						IP: "10.0.0.1",
+					},
+				},
+			},
+			"a": &Server{
+				Hostname: "a",
+				Networks: map[string]*Network{
+					"b/c": &Network{
+						IP: "10.0.0.2",
+					},
+				},
+			},
+			"__any__": &Server{
+				Hostname: "any",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.0.3",
+					},
+				},
+			},
+		},
+	}
+	bs, err = fw.DOT()
+	unittest.IsNil(t, err)
+	unittest.Equals(t, strings.Contains(string(bs), "\t\t\"network/a%2Fb/c\" [shape=ellipse"), true)
+	unittest.Equals(t, strings.Contains(string(bs), "\t\t\"network/a/b%2Fc\" [shape=ellipse"), true)
+	unittest.Equals(t, strings.Contains(string(bs), "\t\t\"server/__any__\" [shape=point"), true)
+	unittest.Equals(t, strings.Count(string(bs), "\"__any__\" ["), 1)
+
+	// an invalid firewall can't be drawn
+	fw = &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers: map[string]*Server{
+			"Web": &Server{
+				Hostname: "web",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.0.1",
+						ServiceDependencies: map[string]map[string]map[string]*Service{
+							"missing": map[string]map[string]*Service{
+								"lan": map[string]*Service{
+									"http": nil,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	_, err = fw.DOT()
+	unittest.NotNil(t, err)
+}
@@ -2,10 +2,12 @@ package firewall
 
 import (
 	"log"
+	"sort"
 )
 
 const (
 	FIREWALL_IPTABLES = iota + 1
+	FIREWALL_NFTABLES
 )
 
 type Firewall struct {
@@ -15,6 +17,8 @@ type Firewall struct {
 	// Global Firewall Rules
 	// list of types can be found in `firewall.go`
 	// 1 can be used for iptables
+	// 2 can be used for nftables
+	// additional types can be registered with `RegisterRenderer`
 	FirewallType int `json:"firewall-type,omitempty"`
 	// Before Server.FirewallRulesBefore
 	FirewallRulesBefore []*Firewall_Rule `json:"firewall-rules-before,omitempty"`
@@ -22,131 +26,288 @@ type Firewall struct {
 	FirewallRulesAfter []*Firewall_Rule `json:"firewall-rules-after,omitempty"`
 	// Global Variables
 	Vars map[string]interface{} `json:"vars,omitempty"`
+	// Named Rules
+	// [SnippetName]Rule
+	// any rule can be replaced with a snippet, see `Firewall_Rule.Snippet`
+	Snippets map[string]*Firewall_Rule `json:"snippets,omitempty"`
 }
 
 func (self *Firewall) IsValid() bool {
-	if self == nil {
-		log.Println("firewall nil")
+	if err := self.Check(); err != nil {
+		log.Printf("firewall invalid: %s\n", err)
 		return false
 	}
-	if self.FirewallType != FIREWALL_IPTABLES {
-		log.Printf("firewall.FirewallType: %d INVALID\n", self.FirewallType)
-		return false
+	return true
+}
+
+// Check returns a Firewall_Error for the first invalid attribute
+// Check doesn't include the relations between our servers, see Validate
+func (self *Firewall) Check() error {
+	return self.validate().err()
+}
+
+// Validate returns every problem in our config, sorted by their path
+// this includes the relations between our servers, service dependencies and hosts dependencies
+// port conflicts between our services aren't included, they're returned by PortConflicts
+func (self *Firewall) Validate() Firewall_Errors {
+	errs := self.validate()
+	if self == nil {
+		return errs
+	}
+	for name, server := range self.Servers {
+		if server == nil {
+			continue
+		}
+		// the acquirers of every server are checked by their own dependencies
+		errs = append(errs, self.validateDependencies(name, server)...)
+		errs = append(errs, self.validateHostsDependencies(name, server)...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Sort(errs)
+	return errs
+}
+func (self *Firewall) validate() Firewall_Errors {
+	if self == nil {
+		return Firewall_Errors{newError("", "firewall nil")}
+	}
+	var errs Firewall_Errors
+	if GetRenderer(self.FirewallType) == nil {
+		errs = append(errs, newError("firewall-type", "%d INVALID", self.FirewallType))
 	}
 	if len(self.Servers) == 0 {
-		log.Println("firewall.Servers empty")
-		return false
+		errs = append(errs, newError("servers", "empty"))
 	}
 	for name, server := range self.Servers {
 		if name == "" {
-			log.Println("firewall.Servers[] name empty")
-			return false
-		}
-		if !server.IsValid() {
-			log.Printf("firewall.Servers[%s] server invalid\n", name)
-			return false
+			errs = append(errs, newError("servers", "name empty"))
 		}
+		errs = append(errs, server.validate(serverPath(name))...)
 	}
 	// FirewallRulesBefore can be empty
-	for _, rule := range self.FirewallRulesBefore {
-		if !rule.IsValid() {
-			log.Println("firewall.FirewallRulesBefore rule invalid")
-			return false
-		}
+	for i, rule := range self.FirewallRulesBefore {
+		errs = append(errs, rule.validate(indexPath("firewall-rules-before", i))...)
 	}
 	// FirewallRulesAfter can be empty
-	for _, rule := range self.FirewallRulesAfter {
-		if !rule.IsValid() {
-			log.Println("firewall.FirewallRulesAfter rule invalid")
-			return false
+	for i, rule := range self.FirewallRulesAfter {
+		errs = append(errs, rule.validate(indexPath("firewall-rules-after", i))...)
+	}
+	// Snippets can be empty
+	errs = append(errs, self.validateSnippets()...)
+	return errs
+}
+
+// walkRules calls fn with every rule of our config and its path
+// snippets aren't included
+func (self *Firewall) walkRules(
+	fn func(path string, rule *Firewall_Rule),
+) {
+	rules := func(path string, rules []*Firewall_Rule) {
+		for i, rule := range rules {
+			if rule != nil {
+				fn(indexPath(path, i), rule)
+			}
+		}
+	}
+	rules("firewall-rules-before", self.FirewallRulesBefore)
+	rules("firewall-rules-after", self.FirewallRulesAfter)
+	for name, server := range self.Servers {
+		if server == nil {
+			continue
+		}
+		path := serverPath(name)
+		rules(joinPath(path, "firewall-before"), server.FirewallRulesBefore)
+		rules(joinPath(path, "firewall-after"), server.FirewallRulesAfter)
+		for network_name, network := range server.Networks {
+			if network == nil {
+				continue
+			}
+			path := joinPath(path, "networks", network_name)
+			rules(joinPath(path, "firewall-rules-before"), network.FirewallRulesBefore)
+			rules(joinPath(path, "firewall-rules-after"), network.FirewallRulesAfter)
+			for service_name, service := range network.ServicesPassive {
+				if service != nil {
+					rules(joinPath(path, "services-passive", service_name, "rules"), service.FirewallRules)
+				}
+			}
+			for service_name, service := range network.ServicesAcquirable {
+				if service != nil {
+					rules(joinPath(path, "services-acquirable", service_name, "rules"), service.FirewallRules)
+				}
+			}
+			// [ServerName][NetworkName][ServiceName]Service
+			for server_name2, networks2 := range network.ServiceDependencies {
+				for network_name2, services2 := range networks2 {
+					for service_name2, service := range services2 {
+						// dependency services are optional
+						if service != nil {
+							rules(joinPath(path, "service-dependencies", server_name2, network_name2, service_name2, "rules"), service.FirewallRules)
+						}
+					}
+				}
+			}
 		}
 	}
-	return true
 }
 
-func (self *Firewall) isFirewallValid(
+func (self *Firewall) checkFirewall(
 	name string,
 	server *Server,
-) bool {
+	index *Firewall_Index,
+) error {
 	// this function is only for checking our relations of our dependencies
+	errs := self.validateAcquirers(name, server, index)
+	// we have to check out dependencies
+	// if we're building every server this will automatically be checked by our acquirers overtime
+	// if we're building an individual server we need to check them now, so this is always going to be checked
+	errs = append(errs, self.validateDependencies(name, server)...)
+	errs = append(errs, self.validateHostsDependencies(name, server)...)
+	return errs.err()
+}
+func (self *Firewall) validateAcquirers(
+	name string,
+	server *Server,
+	index *Firewall_Index,
+) Firewall_Errors {
+	var errs Firewall_Errors
 	// check external dependencies that rely on us
-	// check if any servers have acquired services from this network
-	for server_name2, server2 := range self.Servers {
+	// our index contains every network that has acquired services from us
+	for _, consumer := range index.Consumers(name) {
 		// compare the name not the server object
 		// since we may reuse the same object under different names
-		if name == server_name2 {
+		if name == consumer.ServerName {
 			// this is ourself!!!
-			// make sure that we don't depend on ourselves
-			for _, network := range server.Networks {
-				if _, ok := network.ServiceDependencies[name]; ok {
-					// we rely on ourselves!!!
-					log.Printf("isFirewallValid(%s) this server requires dependencies from its self!!!\n", name)
-					return false
-				}
-			}
-			// we don't rely on ourself
-		} else {
-			// check their server networks
-			for _, network2 := range server2.Networks {
-				// check if they depend on our server
-				// [ServerName][NetworkName][ServiceName]Service
-				if networks2, ok := network2.ServiceDependencies[name]; ok {
-					// network2 depends on us
-					// compare all the networks that network2 depends on us for
-					// if we don't find a network that they depend on us for we have to fail
-					// [NetworkName][ServiceName]Service
-					for network_name2, services2 := range networks2 {
-						// check to make sure the network exists in our server
-						if _, ok := server.Networks[network_name2]; !ok {
-							// network doesn't exist
-							log.Printf("isFirewallValid(%s) acquirable server: \"%s\" requested network: \"%s\" that doesn't exist\n", name, server_name2, network_name2)
-							return false
-						}
-						// compare all services that they depend on us for
-						// if we don't find a service they depend on us for we have to fail
-						for service_name2, _ := range services2 {
-							// check to make sure the service exists in our network
-							if _, ok := server.Networks[network_name2].ServicesAcquirable[service_name2]; !ok {
-								// network service doesn't exist
-								log.Printf("buildFirewallIPTables(%s) acquirable server: \"%s\" requested network: \"%s\" service: \"%s\" that doesn't exist\n", name, server_name2, network_name2, service_name2)
-								return false
-							}
-						}
-					}
-				}
+			// this is checked with our dependencies
+			continue
+		}
+		// network2 depends on us
+		// compare all the networks that network2 depends on us for
+		// if we don't find a network that they depend on us for we have to fail
+		network_name2 := consumer.ProviderNetworkName
+		path := joinPath(serverPath(consumer.ServerName), "networks", consumer.NetworkName, "service-dependencies", name, network_name2)
+		// check to make sure the network exists in our server
+		if _, ok := server.Networks[network_name2]; !ok {
+			// network doesn't exist
+			errs = append(errs, newError(path, "acquirable server: \"%s\" requested network: \"%s\" that doesn't exist", name, network_name2))
+			continue
+		}
+		// acquirers must use the same IP family as our network
+		if consumer.Network.Family() != server.Networks[network_name2].Family() {
+			errs = append(errs, newError(path, "acquirable server: \"%s\" requested network: \"%s\" from a different IP family", name, network_name2))
+		}
+		// compare all services that they depend on us for
+		// if we don't find a service they depend on us for we have to fail
+		for service_name2, _ := range consumer.Services {
+			// check to make sure the service exists in our network
+			if _, ok := server.Networks[network_name2].ServicesAcquirable[service_name2]; !ok {
+				// network service doesn't exist
+				errs = append(errs, newError(joinPath(path, service_name2), "acquirable server: \"%s\" requested network: \"%s\" service: \"%s\" that doesn't exist", name, network_name2, service_name2))
 			}
 		}
 	}
-	// we have to check out dependencies
-	// if we're building every server this will automatically be checked above overtime
-	// if we're building an individual server we need to check them now, so this is always going to be checked
-	for _, network := range server.Networks {
+	return errs
+}
+func (self *Firewall) validateDependencies(
+	name string,
+	server *Server,
+) Firewall_Errors {
+	var errs Firewall_Errors
+	for network_name, network := range server.Networks {
+		if network == nil {
+			continue
+		}
 		// check that server exists
 		// [ServerName][NetworkName][ServiceName]Service
 		for server_name2, networks2 := range network.ServiceDependencies {
-			if _, ok := self.Servers[server_name2]; !ok {
-				log.Printf("buildFirewallIPTables(%s) dependent server: \"%s\" doesn't exist\n", name, server_name2)
-				return false
+			path := joinPath(serverPath(name), "networks", network_name, "service-dependencies", server_name2)
+			// compare the name not the server object
+			// since we may reuse the same object under different names
+			if name == server_name2 {
+				// we rely on ourselves!!!
+				errs = append(errs, newError(path, "this server requires dependencies from its self!!!"))
+				continue
+			}
+			server2, ok := self.Servers[server_name2]
+			if !ok || server2 == nil {
+				errs = append(errs, newError(path, "dependent server: \"%s\" doesn't exist", server_name2))
+				continue
 			}
 			// server found
 			// check that network exists
 			// [NetworkName][ServiceName]Service
 			for network_name2, services2 := range networks2 {
-				if _, ok := self.Servers[server_name2].Networks[network_name2]; !ok {
-					log.Printf("buildFirewallIPTables(%s) dependent server: \"%s\" network: \"%s\" doesn't exist\n", name, server_name2, network_name2)
-					return false
+				network2, ok := server2.Networks[network_name2]
+				if !ok || network2 == nil {
+					errs = append(errs, newError(joinPath(path, network_name2), "dependent server: \"%s\" network: \"%s\" doesn't exist", server_name2, network_name2))
+					continue
+				}
+				// dependencies must use the same IP family as our network
+				if network.Family() != network2.Family() {
+					errs = append(errs, newError(joinPath(path, network_name2), "dependent server: \"%s\" network: \"%s\" is a different IP family", server_name2, network_name2))
 				}
 				// network found
 				// check that service exists
 				// [ServiceName]Service
 				for service_name2, _ := range services2 {
-					if _, ok := self.Servers[server_name2].Networks[network_name2].ServicesAcquirable[service_name2]; !ok {
-						log.Printf("buildFirewallIPTables(%s) dependent server: \"%s\" network: \"%s\" service: \"%s\" doesn't exist\n", name, server_name2, network_name2, service_name2)
-						return false
+					if _, ok := network2.ServicesAcquirable[service_name2]; !ok {
+						errs = append(errs, newError(joinPath(path, network_name2, service_name2), "dependent server: \"%s\" network: \"%s\" service: \"%s\" doesn't exist", server_name2, network_name2, service_name2))
 					}
 				}
 			}
 		}
 	}
-	return true
+	return errs
+}
+func (self *Firewall) validateHostsDependencies(
+	name string,
+	server *Server,
+) Firewall_Errors {
+	var errs Firewall_Errors
+	// [ServerName][]Network
+	for server_name, networks := range server.HostsDependencies {
+		path := joinPath(serverPath(name), "hosts-dependencies", server_name)
+		if name == server_name {
+			errs = append(errs, newError(path, "Acquired Server Name is the same as our Server Name"))
+			continue
+		}
+		s, ok := self.Servers[server_name]
+		if !ok || s == nil {
+			errs = append(errs, newError(path, "Acquired Server not found: \"%s\"", server_name))
+			continue
+		}
+		for i, network := range networks {
+			if _, ok := s.Networks[network]; !ok {
+				errs = append(errs, newError(indexPath(path, i), "Acquired Server Network not found: \"%s\" -> \"%s\"", server_name, network))
+			}
+		}
+	}
+	return errs
+}
+
+// sortedNetworks returns the names of the networks of server
+func sortedNetworks(
+	server *Server,
+) []string {
+	sorted := []string{}
+	for name, network := range server.Networks {
+		if network == nil {
+			continue
+		}
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// sortedServices returns the names of services
+func sortedServices(
+	services map[string]*Service,
+) []string {
+	sorted := []string{}
+	for name, _ := range services {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
 }
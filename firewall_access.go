@@ -0,0 +1,228 @@
+package firewall
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Firewall_Access is a single entry of our access matrix
+// a passive service is open to any source, so our source is empty
+// an acquirable service is only open to the networks that acquired it with a service dependency
+// this is the access our config intends, it's not read from our generated firewalls
+type Firewall_Access struct {
+	SourceServerName       string
+	SourceNetworkName      string
+	DestinationServerName  string
+	DestinationNetworkName string
+	ServiceName            string
+	Service                *Service
+	Passive                bool
+	// location in our config that grants this access
+	// ie: "servers.MyPC.networks.lan.service-dependencies.MediaServer.lan.mysql"
+	Path string
+}
+
+// String returns our access as "source -> destination", ie: "MyPC/lan -> MediaServer/lan/mysql:3306"
+// the source of a passive service is "any"
+// a service without a port is written with the ports of its rules, ie: "Web/lan/apps:8080,9000-9100"
+func (self *Firewall_Access) String() string {
+	source := "any"
+	if !self.Passive {
+		source = fmt.Sprintf("%s/%s", self.SourceServerName, self.SourceNetworkName)
+	}
+	port := fmt.Sprintf("%d", self.Service.Port)
+	if self.Service.Port == 0 {
+		ports := []string{}
+		for _, p := range servicePorts(self.Service) {
+			if p.from == p.to {
+				ports = append(ports, fmt.Sprintf("%d", p.from))
+			} else {
+				ports = append(ports, fmt.Sprintf("%d-%d", p.from, p.to))
+			}
+		}
+		if len(ports) > 0 {
+			port = strings.Join(ports, ",")
+		}
+	}
+	return fmt.Sprintf(
+		"%s -> %s/%s/%s:%s",
+		source,
+		self.DestinationServerName,
+		self.DestinationNetworkName,
+		self.ServiceName,
+		port,
+	)
+}
+
+// Access returns our access matrix
+// it's read from ServicesPassive, ServicesAcquirable and ServiceDependencies
+// a service dependency on a service that isn't acquirable grants nothing, it's reported by validation
+// access is sorted by destination server, network and service, then by source server and network, passive access is first
+func (self *Firewall) Access() []*Firewall_Access {
+	access := []*Firewall_Access{}
+	if self == nil {
+		return access
+	}
+	index := self.Index()
+	for name, server := range self.Servers {
+		if server == nil {
+			continue
+		}
+		for network_name, network := range server.Networks {
+			if network == nil {
+				continue
+			}
+			for service_name, service := range network.ServicesPassive {
+				if service == nil {
+					continue
+				}
+				access = append(access, &Firewall_Access{
+					DestinationServerName:  name,
+					DestinationNetworkName: network_name,
+					ServiceName:            service_name,
+					Service:                service,
+					Passive:                true,
+					Path:                   joinPath(serverPath(name), "networks", network_name, "services-passive", service_name),
+				})
+			}
+		}
+		for _, consumer := range index.Consumers(name) {
+			network := server.Networks[consumer.ProviderNetworkName]
+			if network == nil {
+				continue
+			}
+			for service_name, _ := range consumer.Services {
+				service := network.ServicesAcquirable[service_name]
+				if service == nil {
+					continue
+				}
+				access = append(access, &Firewall_Access{
+					SourceServerName:       consumer.ServerName,
+					SourceNetworkName:      consumer.NetworkName,
+					DestinationServerName:  name,
+					DestinationNetworkName: consumer.ProviderNetworkName,
+					ServiceName:            service_name,
+					Service:                service,
+					Path:                   joinPath(serverPath(consumer.ServerName), "networks", consumer.NetworkName, "service-dependencies", name, consumer.ProviderNetworkName, service_name),
+				})
+			}
+		}
+	}
+	sort.Slice(access, func(i, j int) bool {
+		a, b := access[i], access[j]
+		if a.DestinationServerName != b.DestinationServerName {
+			return a.DestinationServerName < b.DestinationServerName
+		}
+		if a.DestinationNetworkName != b.DestinationNetworkName {
+			return a.DestinationNetworkName < b.DestinationNetworkName
+		}
+		if a.ServiceName != b.ServiceName {
+			return a.ServiceName < b.ServiceName
+		}
+		if a.Passive != b.Passive {
+			return a.Passive
+		}
+		if a.SourceServerName != b.SourceServerName {
+			return a.SourceServerName < b.SourceServerName
+		}
+		return a.SourceNetworkName < b.SourceNetworkName
+	})
+	return access
+}
+
+// CanReach returns the access that allows src to reach port of dst, or nil if it can't
+// a service dependency of src is returned before a passive service, since it's specific to src
+// a passive service is only reachable if src has a network of the same IP family
+// services are matched by the ports they open, the same as `PortConflicts`, a service that doesn't open a port is matched by port 0
+// nil is returned if src isn't one of our servers or if src is dst
+func (self *Firewall) CanReach(
+	src string,
+	dst string,
+	port uint16,
+) *Firewall_Access {
+	if self == nil ||
+		src == dst {
+		return nil
+	}
+	server := self.Servers[src]
+	if server == nil {
+		return nil
+	}
+	// [Family]
+	families := make(map[int]bool)
+	for _, network := range server.Networks {
+		if network != nil {
+			families[network.Family()] = true
+		}
+	}
+	var passive *Firewall_Access
+	for _, access := range self.Access() {
+		if access.DestinationServerName != dst ||
+			!serviceOpens(access.Service, port) {
+			continue
+		}
+		if access.Passive {
+			if passive == nil &&
+				families[self.Servers[dst].Networks[access.DestinationNetworkName].Family()] {
+				passive = access
+			}
+			continue
+		}
+		if access.SourceServerName == src {
+			return access
+		}
+	}
+	return passive
+}
+
+// serviceOpens returns true if service opens port, see `servicePorts`
+func serviceOpens(
+	service *Service,
+	port uint16,
+) bool {
+	ports := servicePorts(service)
+	if len(ports) == 0 {
+		return port == 0
+	}
+	for _, p := range ports {
+		if p.from <= port &&
+			port <= p.to {
+			return true
+		}
+	}
+	return false
+}
+
+// Consumers returns the access to a service of network of server
+// a passive service is returned with an empty source, followed by every network that acquired the service
+func (self *Firewall) Consumers(
+	server string,
+	network string,
+	service string,
+) []*Firewall_Access {
+	consumers := []*Firewall_Access{}
+	for _, access := range self.Access() {
+		if access.DestinationServerName == server &&
+			access.DestinationNetworkName == network &&
+			access.ServiceName == service {
+			consumers = append(consumers, access)
+		}
+	}
+	return consumers
+}
+
+// Providers returns the access that server acquired from other servers with its service dependencies
+// passive services aren't included, since every server can reach them
+func (self *Firewall) Providers(
+	server string,
+) []*Firewall_Access {
+	providers := []*Firewall_Access{}
+	for _, access := range self.Access() {
+		if !access.Passive &&
+			access.SourceServerName == server {
+			providers = append(providers, access)
+		}
+	}
+	return providers
+}
@@ -0,0 +1,156 @@
+package firewall
+
+import (
+	"fmt"
+	"github.com/sabey/unittest"
+	"strings"
+	"testing"
+)
+
+func TestFirewallAccess(t *testing.T) {
+	fmt.Println("TestFirewallAccess")
+	rules := []*Firewall_Rule{
+		&Firewall_Rule{
+			Rule: "# {{.ServiceName}}",
+		},
+	}
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers: map[string]*Server{
+			"Web": &Server{
+				Hostname: "web",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.0.1",
+						ServicesPassive: map[string]*Service{
+							"ssh": &Service{
+								Port:          22,
+								FirewallRules: rules,
+							},
+						},
+						ServicesAcquirable: map[string]*Service{
+							"ssh": &Service{
+								Port:          22,
+								FirewallRules: rules,
+							},
+							"http": &Service{
+								Port:          80,
+								FirewallRules: rules,
+							},
+							// our ports are read from our rule
+							"apps": &Service{
+								FirewallRules: []*Firewall_Rule{
+									&Firewall_Rule{
+										Protocol: "tcp",
+										Ports:    "8080,9000-9100",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"Client": &Server{
+				Hostname: "client",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.0.2",
+						ServiceDependencies: map[string]map[string]map[string]*Service{
+							"Web": map[string]map[string]*Service{
+								"lan": map[string]*Service{
+									"ssh":  nil,
+									"http": nil,
+									"apps": nil,
+									// a service that isn't acquirable grants nothing
+									"mysql": nil,
+								},
+							},
+						},
+					},
+					"vpn": &Network{
+						IP: "10.1.0.2",
+						ServiceDependencies: map[string]map[string]map[string]*Service{
+							"Web": map[string]map[string]*Service{
+								"lan": map[string]*Service{
+									"http": nil,
+								},
+							},
+						},
+					},
+				},
+			},
+			// without any service dependencies
+			"Admin": &Server{
+				Hostname: "admin",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.0.3",
+					},
+				},
+			},
+			// only IPv6 can't reach an IPv4 passive service
+			"IPv6": &Server{
+				Hostname: "ipv6",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "fd00::4",
+					},
+				},
+			},
+		},
+	}
+	access := fw.Access()
+	lines := []string{}
+	for _, a := range access {
+		lines = append(lines, fmt.Sprintf("%s\t%s", a, a.Path))
+	}
+	unittest.Equals(t, strings.Join(lines, "\n"), strings.Join([]string{
+		"Client/lan -> Web/lan/apps:8080,9000-9100\tservers.Client.networks.lan.service-dependencies.Web.lan.apps",
+		"Client/lan -> Web/lan/http:80\tservers.Client.networks.lan.service-dependencies.Web.lan.http",
+		"Client/vpn -> Web/lan/http:80\tservers.Client.networks.vpn.service-dependencies.Web.lan.http",
+		"any -> Web/lan/ssh:22\tservers.Web.networks.lan.services-passive.ssh",
+		"Client/lan -> Web/lan/ssh:22\tservers.Client.networks.lan.service-dependencies.Web.lan.ssh",
+	}, "\n"))
+
+	// our service dependency is more specific than a passive service
+	grant := fw.CanReach("Client", "Web", 22)
+	unittest.NotNil(t, grant)
+	unittest.Equals(t, grant.Passive, false)
+	unittest.Equals(t, grant.SourceNetworkName, "lan")
+	// passive services can be reached by any server of the same IP family
+	grant = fw.CanReach("Admin", "Web", 22)
+	unittest.NotNil(t, grant)
+	unittest.Equals(t, grant.Passive, true)
+	unittest.Equals(t, grant.Path, "servers.Web.networks.lan.services-passive.ssh")
+	unittest.IsNil(t, fw.CanReach("IPv6", "Web", 22))
+	grant = fw.CanReach("Client", "Web", 80)
+	unittest.NotNil(t, grant)
+	unittest.Equals(t, grant.ServiceName, "http")
+	unittest.IsNil(t, fw.CanReach("Admin", "Web", 80))
+	grant = fw.CanReach("Client", "Web", 8080)
+	unittest.NotNil(t, grant)
+	unittest.Equals(t, grant.ServiceName, "apps")
+	grant = fw.CanReach("Client", "Web", 9050)
+	unittest.NotNil(t, grant)
+	unittest.Equals(t, grant.ServiceName, "apps")
+	unittest.IsNil(t, fw.CanReach("Client", "Web", 8081))
+	unittest.IsNil(t, fw.CanReach("Client", "Web", 0))
+	unittest.IsNil(t, fw.CanReach("Web", "Client", 80))
+	// unknown servers and ourself
+	unittest.IsNil(t, fw.CanReach("Other", "Web", 22))
+	unittest.IsNil(t, fw.CanReach("Web", "Web", 22))
+
+	consumers := fw.Consumers("Web", "lan", "ssh")
+	unittest.Equals(t, len(consumers), 2)
+	unittest.Equals(t, consumers[0].Passive, true)
+	unittest.Equals(t, consumers[1].SourceServerName, "Client")
+	unittest.Equals(t, len(fw.Consumers("Web", "lan", "http")), 2)
+	unittest.Equals(t, len(fw.Consumers("Web", "lan", "mysql")), 0)
+
+	providers := fw.Providers("Client")
+	unittest.Equals(t, len(providers), 4)
+	for _, provider := range providers {
+		unittest.Equals(t, provider.DestinationServerName, "Web")
+	}
+	unittest.Equals(t, len(fw.Providers("Web")), 0)
+}
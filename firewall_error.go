@@ -0,0 +1,136 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Firewall_Error is returned by Check, Generate and GenerateServer
+// Path is the location of the error in our config, using our json attribute names
+// ie: "servers.MyPC.networks.lan.services-acquirable.mysql.rules[0]"
+// errors returned while loading a config also have the File they were found in
+// JSON files also have the 1 based Line and Column of the error
+type Firewall_Error struct {
+	Path   string
+	Err    error
+	File   string
+	Line   int
+	Column int
+}
+
+func (self *Firewall_Error) Error() string {
+	s := self.Err.Error()
+	if self.Path != "" {
+		s = fmt.Sprintf("%s: %s", self.Path, s)
+	}
+	if self.Line > 0 {
+		s = fmt.Sprintf("%s at line %d:%d", s, self.Line, self.Column)
+	}
+	if self.File != "" {
+		s = fmt.Sprintf("%s: %s", self.File, s)
+	}
+	return s
+}
+func (self *Firewall_Error) Unwrap() error {
+	return self.Err
+}
+
+// newError creates an error at path
+func newError(
+	path string,
+	format string,
+	a ...interface{},
+) *Firewall_Error {
+	return &Firewall_Error{
+		Path: path,
+		Err:  fmt.Errorf(format, a...),
+	}
+}
+
+// wrapError wraps err with path
+// if err is already a Firewall_Error it's returned as is, since its path is more specific
+func wrapError(
+	path string,
+	err error,
+) error {
+	if err == nil {
+		return nil
+	}
+	var ferr *Firewall_Error
+	if errors.As(err, &ferr) {
+		return err
+	}
+	return &Firewall_Error{
+		Path: path,
+		Err:  err,
+	}
+}
+
+// joinPath appends a key to path
+func joinPath(
+	path string,
+	keys ...string,
+) string {
+	for _, key := range keys {
+		if path == "" {
+			path = key
+		} else {
+			path = fmt.Sprintf("%s.%s", path, key)
+		}
+	}
+	return path
+}
+
+// indexPath appends an index to path
+func indexPath(
+	path string,
+	i int,
+) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// serverPath returns the path of a server
+func serverPath(
+	name string,
+) string {
+	return joinPath("servers", name)
+}
+
+// Firewall_Errors is every error found by Validate
+// errors are sorted by their path so they're deterministic
+type Firewall_Errors []*Firewall_Error
+
+func (self Firewall_Errors) Error() string {
+	lines := []string{}
+	for _, err := range self {
+		lines = append(lines, err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+func (self Firewall_Errors) Len() int {
+	return len(self)
+}
+func (self Firewall_Errors) Less(
+	i, j int,
+) bool {
+	if self[i].Path != self[j].Path {
+		return self[i].Path < self[j].Path
+	}
+	return self[i].Err.Error() < self[j].Err.Error()
+}
+func (self Firewall_Errors) Swap(
+	i, j int,
+) {
+	self[i], self[j] = self[j], self[i]
+}
+
+// err returns our first error or nil, this is used by Check
+func (self Firewall_Errors) err() error {
+	if len(self) == 0 {
+		return nil
+	}
+	sort.Sort(self)
+	return self[0]
+}
@@ -0,0 +1,174 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"github.com/sabey/unittest"
+	"strings"
+	"testing"
+)
+
+func TestFirewallError(t *testing.T) {
+	fmt.Println("TestFirewallError")
+	settings := &Settings{
+		BuildPath: "unittest",
+	}
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers: map[string]*Server{
+			"MediaServer": &Server{
+				Hostname: "media-server",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "192.168.1.31",
+						ServicesAcquirable: map[string]*Service{
+							"mysql": &Service{
+								Port: 3306,
+								FirewallRules: []*Firewall_Rule{
+									&Firewall_Rule{
+										Rule: "-A INPUT -p tcp --src {{.SourceNetwork.IP}} --dport {{.DestinationService.Vars.missing}} -j ACCEPT",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"MyPC": &Server{
+				Hostname: "home",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "192.168.1.13",
+						ServiceDependencies: map[string]map[string]map[string]*Service{
+							"MediaServer": map[string]map[string]*Service{
+								"lan": map[string]*Service{
+									"mysql": nil,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	// template errors
+	err := fw.Generate(settings)
+	unittest.NotNil(t, err)
+	var ferr *Firewall_Error
+	unittest.Equals(t, errors.As(err, &ferr), true)
+	unittest.Equals(t, ferr.Path, "servers.MediaServer.networks.lan.services-acquirable.mysql.rules[0]")
+	unittest.NotNil(t, ferr.Unwrap())
+	unittest.Equals(t, fw.Build(settings), false)
+
+	// validation errors
+	fw.Servers["MediaServer"].Networks["lan"].ServicesAcquirable["mysql"].FirewallRules[0] = &Firewall_Rule{}
+	err = fw.Check()
+	unittest.Equals(t, errors.As(err, &ferr), true)
+	unittest.Equals(t, ferr.Path, "servers.MediaServer.networks.lan.services-acquirable.mysql.rules[0].rule")
+	fw.Servers["MediaServer"].Networks["lan"].ServicesAcquirable["mysql"].FirewallRules[0] = &Firewall_Rule{
+		Rule: "-A INPUT -p tcp --src {{.SourceNetwork.IP}} --dport {{.DestinationService.Port}} -j ACCEPT",
+	}
+	fw.Servers["MyPC"].Networks["lan"].IP = "192.168.1"
+	err = fw.Check()
+	unittest.Equals(t, errors.As(err, &ferr), true)
+	unittest.Equals(t, ferr.Path, "servers.MyPC.networks.lan.ip")
+	unittest.Equals(t, err.Error(), "servers.MyPC.networks.lan.ip: \"192.168.1\" invalid")
+
+	// dependency errors
+	fw.Servers["MyPC"].Networks["lan"].IP = "192.168.1.13"
+	fw.Servers["MyPC"].Networks["lan"].ServiceDependencies["MediaServer"]["lan"]["ssh"] = nil
+	err = fw.GenerateServer(settings, "MyPC")
+	unittest.Equals(t, errors.As(err, &ferr), true)
+	unittest.Equals(t, ferr.Path, "servers.MyPC.networks.lan.service-dependencies.MediaServer.lan.ssh")
+	delete(fw.Servers["MyPC"].Networks["lan"].ServiceDependencies["MediaServer"]["lan"], "ssh")
+	err = fw.GenerateServer(settings, "Missing")
+	unittest.Equals(t, errors.As(err, &ferr), true)
+	unittest.Equals(t, ferr.Path, "servers.Missing")
+
+	unittest.IsNil(t, fw.GenerateServer(settings, "MyPC"))
+}
+func TestFirewallValidate(t *testing.T) {
+	fmt.Println("TestFirewallValidate")
+	fw := &Firewall{
+		FirewallType: 100000,
+		Servers: map[string]*Server{
+			"MediaServer": &Server{
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "192.168.1.31",
+						ServicesAcquirable: map[string]*Service{
+							"mysql": &Service{
+								Port: 3306,
+							},
+						},
+					},
+				},
+			},
+			"MyPC": &Server{
+				Hostname: "home",
+				HostsDependencies: map[string][]string{
+					"MediaServer": []string{
+						"lan",
+						"wan",
+					},
+					"Missing": []string{
+						"lan",
+					},
+				},
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "192.168.1.13",
+						ServiceDependencies: map[string]map[string]map[string]*Service{
+							"MediaServer": map[string]map[string]*Service{
+								"lan": map[string]*Service{
+									"mysql": nil,
+									"ssh":   nil,
+								},
+								"wan": map[string]*Service{
+									"ssh": nil,
+								},
+							},
+							"MyPC": map[string]map[string]*Service{
+								"lan": map[string]*Service{
+									"ssh": nil,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	errs := fw.Validate()
+	paths := []string{}
+	for _, err := range errs {
+		paths = append(paths, err.Path)
+	}
+	unittest.Equals(t, strings.Join(paths, "\n"), strings.Join([]string{
+		"firewall-type",
+		"servers.MediaServer.hostname",
+		"servers.MediaServer.networks.lan.services-acquirable.mysql.rules",
+		"servers.MyPC.hosts-dependencies.MediaServer[1]",
+		"servers.MyPC.hosts-dependencies.Missing",
+		"servers.MyPC.networks.lan.service-dependencies.MediaServer.lan.ssh",
+		"servers.MyPC.networks.lan.service-dependencies.MediaServer.wan",
+		"servers.MyPC.networks.lan.service-dependencies.MyPC",
+	}, "\n"))
+	// check only returns the first error
+	var ferr *Firewall_Error
+	unittest.Equals(t, errors.As(fw.Check(), &ferr), true)
+	unittest.Equals(t, ferr.Path, "firewall-type")
+	unittest.IsNil(t, (&Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers: map[string]*Server{
+			"solo": &Server{
+				Hostname: "solo",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "192.168.1.1",
+					},
+				},
+			},
+		},
+	}).Validate())
+}
@@ -0,0 +1,113 @@
+package firewall
+
+import (
+	"sort"
+)
+
+// Firewall_Index is a reverse index of our service dependencies
+// for every server that provides services it lists the networks that acquired them
+// an index is built once per build and is read only, our config must not be modified while it's in use
+type Firewall_Index struct {
+	// [ProviderServerName][]Consumer
+	consumers map[string][]*Firewall_Index_Consumer
+}
+
+// Firewall_Index_Consumer is a network that acquired services from a network of a provider server
+type Firewall_Index_Consumer struct {
+	ServerName  string
+	Server      *Server
+	NetworkName string
+	Network     *Network
+	// the network of the provider server
+	ProviderNetworkName string
+	// [ServiceName]Service
+	// service is our optional local service, see `Network.ServiceDependencies`
+	Services map[string]*Service
+}
+
+// Index builds the reverse index of our service dependencies
+// dependencies on servers or networks that don't exist are included, they're reported by validation
+func (self *Firewall) Index() *Firewall_Index {
+	index := &Firewall_Index{
+		consumers: make(map[string][]*Firewall_Index_Consumer),
+	}
+	for server_name, server := range self.Servers {
+		if server == nil {
+			continue
+		}
+		for network_name, network := range server.Networks {
+			if network == nil {
+				continue
+			}
+			// [ServerName][NetworkName][ServiceName]Service
+			for server_name2, networks2 := range network.ServiceDependencies {
+				// [NetworkName][ServiceName]Service
+				for network_name2, services2 := range networks2 {
+					index.consumers[server_name2] = append(
+						index.consumers[server_name2],
+						&Firewall_Index_Consumer{
+							ServerName:          server_name,
+							Server:              server,
+							NetworkName:         network_name,
+							Network:             network,
+							ProviderNetworkName: network_name2,
+							Services:            services2,
+						},
+					)
+				}
+			}
+		}
+	}
+	// sort consumers so anything built from our index is deterministic
+	for _, consumers := range index.consumers {
+		sort.Slice(consumers, func(i, j int) bool {
+			if consumers[i].ServerName != consumers[j].ServerName {
+				return consumers[i].ServerName < consumers[j].ServerName
+			}
+			if consumers[i].NetworkName != consumers[j].NetworkName {
+				return consumers[i].NetworkName < consumers[j].NetworkName
+			}
+			return consumers[i].ProviderNetworkName < consumers[j].ProviderNetworkName
+		})
+	}
+	return index
+}
+
+// Consumers returns every network that acquired services from server
+// sorted by consumer server, consumer network and then provider network
+// every consumer and its Services are copies, so our index can't be modified
+// Server, Network and the Services themselves are still our config
+func (self *Firewall_Index) Consumers(
+	server string,
+) []*Firewall_Index_Consumer {
+	consumers := make([]*Firewall_Index_Consumer, 0, len(self.consumers[server]))
+	for _, consumer := range self.consumers[server] {
+		consumers = append(consumers, consumer.copy())
+	}
+	return consumers
+}
+
+// NetworkConsumers returns every network that acquired services from network of server
+// every consumer is a copy, see `Consumers`
+func (self *Firewall_Index) NetworkConsumers(
+	server string,
+	network string,
+) []*Firewall_Index_Consumer {
+	consumers := []*Firewall_Index_Consumer{}
+	for _, consumer := range self.consumers[server] {
+		if consumer.ProviderNetworkName == network {
+			consumers = append(consumers, consumer.copy())
+		}
+	}
+	return consumers
+}
+func (self *Firewall_Index_Consumer) copy() *Firewall_Index_Consumer {
+	consumer := *self
+	if self.Services != nil {
+		consumer.Services = make(map[string]*Service, len(self.Services))
+		for name, service := range self.Services {
+			consumer.Services[name] = service
+		}
+	}
+	return &consumer
+}
@@ -0,0 +1,115 @@
+package firewall
+
+import (
+	"fmt"
+	"github.com/sabey/unittest"
+	"sort"
+	"testing"
+)
+
+func TestFirewallIndex(t *testing.T) {
+	fmt.Println("TestFirewallIndex")
+	fw := fleet(10)
+	index := fw.Index()
+	// Server1 is acquired by the next server and Server3 at double its number
+	consumers := index.Consumers("Server1")
+	names := []string{}
+	for _, consumer := range consumers {
+		unittest.Equals(t, consumer.NetworkName, "lan")
+		unittest.Equals(t, consumer.ProviderNetworkName, "lan")
+		_, ok := consumer.Services["mysql"]
+		unittest.Equals(t, ok, true)
+		names = append(names, consumer.ServerName)
+	}
+	unittest.Equals(t, fmt.Sprint(names), "[Server2 Server3]")
+	unittest.Equals(t, len(index.NetworkConsumers("Server1", "lan")), 2)
+	unittest.Equals(t, len(index.NetworkConsumers("Server1", "wan")), 0)
+	// Server9 is acquired by nobody
+	unittest.Equals(t, len(index.Consumers("Server9")), 0)
+	unittest.Equals(t, len(index.Consumers("missing")), 0)
+	// our index can't be modified
+	consumers[0] = nil
+	unittest.NotNil(t, index.Consumers("Server1")[0])
+	consumer := index.Consumers("Server1")[1]
+	services := len(consumer.Services)
+	consumer.ServerName = "modified"
+	consumer.Services["modified"] = nil
+	consumer = index.NetworkConsumers("Server1", "lan")[1]
+	unittest.Equals(t, consumer.ServerName, "Server3")
+	unittest.Equals(t, len(consumer.Services), services)
+	consumer.Services["modified"] = nil
+	unittest.Equals(t, len(index.Consumers("Server1")[1].Services), services)
+	// the same fleet still renders
+	sorted := []string{}
+	for name, _ := range fw.Servers {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	_, err := fw.renderServers(sorted, 1)
+	unittest.IsNil(t, err)
+}
+func BenchmarkIndex(b *testing.B) {
+	fw := fleet(5000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fw.Index()
+	}
+}
+func BenchmarkRenderFleet(b *testing.B) {
+	fw := fleet(5000)
+	sorted := []string{}
+	for name, _ := range fw.Servers {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := fw.renderServers(sorted, 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// fleet creates a synthetic config of servers
+// every server acquires mysql from the previous server and from the server at half its number
+func fleet(
+	servers int,
+) *Firewall {
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers:      make(map[string]*Server, servers),
+	}
+	for i := 0; i < servers; i++ {
+		network := &Network{
+			IP: fmt.Sprintf("10.%d.%d.%d", i/65536, i/256%256, i%256),
+			ServicesAcquirable: map[string]*Service{
+				"mysql": &Service{
+					Port: 3306,
+					FirewallRules: []*Firewall_Rule{
+						&Firewall_Rule{
+							Rule: "-A INPUT -p tcp --src {{.SourceNetwork.IP}} --dport {{.DestinationService.Port}} -j ACCEPT",
+						},
+					},
+				},
+			},
+			ServiceDependencies: make(map[string]map[string]map[string]*Service),
+		}
+		for _, dependency := range []int{i - 1, i / 2} {
+			if dependency < 0 || dependency == i {
+				continue
+			}
+			network.ServiceDependencies[fmt.Sprintf("Server%d", dependency)] = map[string]map[string]*Service{
+				"lan": map[string]*Service{
+					"mysql": nil,
+				},
+			}
+		}
+		fw.Servers[fmt.Sprintf("Server%d", i)] = &Server{
+			Hostname: fmt.Sprintf("server%d", i),
+			Networks: map[string]*Network{
+				"lan": network,
+			},
+		}
+	}
+	return fw
+}
@@ -0,0 +1,225 @@
+package firewall
+
+import (
+	"sort"
+	"strings"
+	"text/template/parse"
+)
+
+// Lint returns warnings for config that's valid but unused, sorted by their path
+// * acquirable services that aren't acquired by any server
+// * network hosts that aren't referenced by the hosts dependencies of any server
+// * vars that aren't read by any rule or snippet
+// a var is read by ".Vars.key", ie: "{{.Server.Vars.key}}", or by a "key" string, ie: `{{serverVar "Monitor" "key"}}`
+// if any rule reads Vars as a whole, ie: "{{range .Vars}}", every var is read
+// warnings aren't validation errors, a firewall with warnings can be built
+func (self *Firewall) Lint() Firewall_Errors {
+	if self == nil {
+		return nil
+	}
+	var errs Firewall_Errors
+	index := self.Index()
+	// [ServerName][NetworkName]
+	referenced := make(map[string]map[string]bool)
+	for _, server := range self.Servers {
+		if server == nil {
+			continue
+		}
+		for server_name, networks := range server.HostsDependencies {
+			if referenced[server_name] == nil {
+				referenced[server_name] = make(map[string]bool)
+			}
+			for _, network_name := range networks {
+				referenced[server_name][network_name] = true
+			}
+		}
+	}
+	vars := self.lintVars()
+	lintVars := func(path string, levels map[string]interface{}) {
+		if vars == nil {
+			// Vars is read as a whole
+			return
+		}
+		for key, _ := range levels {
+			if !vars[key] {
+				errs = append(errs, newError(joinPath(path, "vars", key), "not read by any rule"))
+			}
+		}
+	}
+	lintVars("", self.Vars)
+	for name, server := range self.Servers {
+		if server == nil {
+			continue
+		}
+		path := serverPath(name)
+		lintVars(path, server.Vars)
+		for network_name, network := range server.Networks {
+			if network == nil {
+				continue
+			}
+			path := joinPath(path, "networks", network_name)
+			lintVars(path, network.Vars)
+			if len(network.Hosts) > 0 &&
+				!referenced[name][network_name] {
+				errs = append(errs, newError(joinPath(path, "hosts"), "not referenced by the hosts-dependencies of any server"))
+			}
+			for service_name, service := range network.ServicesPassive {
+				if service != nil {
+					lintVars(joinPath(path, "services-passive", service_name), service.Vars)
+				}
+			}
+			// [ServiceName]Acquired
+			acquired := make(map[string]bool)
+			for _, consumer := range index.NetworkConsumers(name, network_name) {
+				for service_name, _ := range consumer.Services {
+					acquired[service_name] = true
+				}
+			}
+			for service_name, service := range network.ServicesAcquirable {
+				if !acquired[service_name] {
+					errs = append(errs, newError(joinPath(path, "services-acquirable", service_name), "not acquired by any server"))
+				}
+				if service != nil {
+					lintVars(joinPath(path, "services-acquirable", service_name), service.Vars)
+				}
+			}
+			for server_name2, networks2 := range network.ServiceDependencies {
+				for network_name2, services2 := range networks2 {
+					for service_name2, service := range services2 {
+						if service != nil {
+							lintVars(joinPath(path, "service-dependencies", server_name2, network_name2, service_name2), service.Vars)
+						}
+					}
+				}
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Sort(errs)
+	return errs
+}
+
+// lintVars returns every var key read by our rules and snippets
+// nil is returned if Vars is read as a whole
+// templates that don't compile are ignored, they're reported by validation
+func (self *Firewall) lintVars() map[string]bool {
+	vars := make(map[string]bool)
+	whole := false
+	read := func(rule *Firewall_Rule) {
+		for _, field := range rule.fields() {
+			if !strings.Contains(field.text, "{{") {
+				continue
+			}
+			t, err := rule.compile(field.name, field.text)
+			if err != nil ||
+				t.template.Tree == nil {
+				continue
+			}
+			if !parseVars(t.template.Tree.Root, vars) {
+				whole = true
+			}
+		}
+	}
+	self.walkRules(func(path string, rule *Firewall_Rule) {
+		read(rule)
+	})
+	for _, snippet := range self.Snippets {
+		if snippet != nil {
+			read(snippet)
+		}
+	}
+	if whole {
+		return nil
+	}
+	return vars
+}
+
+// parseVars adds every var key read by node to vars
+// false is returned if Vars is read as a whole
+func parseVars(
+	node parse.Node,
+	vars map[string]bool,
+) bool {
+	ok := true
+	idents := func(ident []string) {
+		for i, name := range ident {
+			if name != "Vars" {
+				continue
+			}
+			if i+1 < len(ident) {
+				vars[ident[i+1]] = true
+			} else {
+				ok = false
+			}
+		}
+	}
+	nodes := []parse.Node{}
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node != nil {
+			nodes = append(nodes, node.Nodes...)
+		}
+	case *parse.ActionNode:
+		nodes = append(nodes, node.Pipe)
+	case *parse.IfNode:
+		nodes = append(nodes, node.Pipe, node.List, node.ElseList)
+	case *parse.RangeNode:
+		nodes = append(nodes, node.Pipe, node.List, node.ElseList)
+	case *parse.WithNode:
+		nodes = append(nodes, node.Pipe, node.List, node.ElseList)
+	case *parse.TemplateNode:
+		nodes = append(nodes, node.Pipe)
+	case *parse.PipeNode:
+		if node != nil {
+			for _, cmd := range node.Cmds {
+				nodes = append(nodes, cmd)
+			}
+		}
+	case *parse.CommandNode:
+		if len(node.Args) > 2 &&
+			isIndexVars(node.Args[0], node.Args[1]) {
+			// {{index .Vars "key"}} only reads our key
+			nodes = append(nodes, node.Args[2:]...)
+			break
+		}
+		nodes = append(nodes, node.Args...)
+	case *parse.FieldNode:
+		idents(node.Ident)
+	case *parse.ChainNode:
+		nodes = append(nodes, node.Node)
+		idents(node.Field)
+	case *parse.VariableNode:
+		idents(node.Ident)
+	case *parse.StringNode:
+		vars[node.Text] = true
+	}
+	for _, node := range nodes {
+		if !parseVars(node, vars) {
+			ok = false
+		}
+	}
+	return ok
+}
+
+// isIndexVars returns true if our command is "index" of Vars, ie: {{index .Server.Vars "key"}}
+func isIndexVars(
+	function parse.Node,
+	arg parse.Node,
+) bool {
+	if ident, ok := function.(*parse.IdentifierNode); !ok || ident.Ident != "index" {
+		return false
+	}
+	var ident []string
+	switch arg := arg.(type) {
+	case *parse.FieldNode:
+		ident = arg.Ident
+	case *parse.ChainNode:
+		ident = arg.Field
+	case *parse.VariableNode:
+		ident = arg.Ident
+	}
+	return len(ident) > 0 &&
+		ident[len(ident)-1] == "Vars"
+}
@@ -0,0 +1,106 @@
+package firewall
+
+import (
+	"fmt"
+	"github.com/sabey/unittest"
+	"strings"
+	"testing"
+)
+
+func TestFirewallLint(t *testing.T) {
+	fmt.Println("TestFirewallLint")
+	rule := func(text string) []*Firewall_Rule {
+		return []*Firewall_Rule{
+			&Firewall_Rule{
+				Rule: text,
+			},
+		}
+	}
+	fw := &Firewall{
+		FirewallType:        FIREWALL_IPTABLES,
+		FirewallRulesBefore: rule(`# {{.Vars.global}} {{index .Server.Vars "indexed"}}`),
+		Vars: map[string]interface{}{
+			"global": "read",
+			"unused": "dead",
+		},
+		Snippets: map[string]*Firewall_Rule{
+			"monitor": &Firewall_Rule{
+				Rule: `# {{serverVar "Web" "monitor"}}`,
+			},
+		},
+		Servers: map[string]*Server{
+			"Web": &Server{
+				Hostname: "web",
+				Vars: map[string]interface{}{
+					"indexed": "read",
+					"monitor": "read",
+				},
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP:    "10.0.0.1",
+						Hosts: []string{"web.lan"},
+						ServicesAcquirable: map[string]*Service{
+							"http": &Service{
+								Port:          80,
+								FirewallRules: rule("# http"),
+							},
+							"mysql": &Service{
+								Port:          3306,
+								FirewallRules: rule("# mysql"),
+								Vars: map[string]interface{}{
+									"user": "dead",
+								},
+							},
+						},
+					},
+					"vpn": &Network{
+						IP:    "10.1.0.1",
+						Hosts: []string{"web.vpn"},
+					},
+				},
+			},
+			"Client": &Server{
+				Hostname: "client",
+				HostsDependencies: map[string][]string{
+					"Web": []string{"vpn"},
+				},
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.0.2",
+						FirewallRulesAfter: []*Firewall_Rule{
+							&Firewall_Rule{
+								Snippet: "monitor",
+							},
+						},
+						ServiceDependencies: map[string]map[string]map[string]*Service{
+							"Web": map[string]map[string]*Service{
+								"lan": map[string]*Service{
+									"http": nil,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	unittest.IsNil(t, fw.Validate())
+	warnings := fw.Lint()
+	unittest.Equals(t, warnings.Error(), strings.Join([]string{
+		"servers.Web.networks.lan.hosts: not referenced by the hosts-dependencies of any server",
+		"servers.Web.networks.lan.services-acquirable.mysql: not acquired by any server",
+		"servers.Web.networks.lan.services-acquirable.mysql.vars.user: not read by any rule",
+		"vars.unused: not read by any rule",
+	}, "\n"))
+
+	// reading Vars as a whole reads every var
+	fw.FirewallRulesAfter = rule("{{range $key, $value := .Vars}}# {{$key}}{{end}}")
+	warnings = fw.Lint()
+	unittest.Equals(t, len(warnings), 2)
+	unittest.Equals(t, warnings[1].Path, "servers.Web.networks.lan.services-acquirable.mysql")
+
+	// a firewall without warnings
+	delete(fw.Servers["Web"].Networks["lan"].ServicesAcquirable, "mysql")
+	fw.Servers["Web"].Networks["lan"].Hosts = nil
+	unittest.IsNil(t, fw.Lint())
+}
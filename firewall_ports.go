@@ -0,0 +1,111 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+func (self *service_port) overlaps(
+	port *service_port,
+) bool {
+	return self.from <= port.to &&
+		port.from <= self.to &&
+		(self.protocol == "" || port.protocol == "" || self.protocol == port.protocol)
+}
+
+// service_ports are the ports of a service on an IP of a server
+type service_ports struct {
+	path  string
+	ports []*service_port
+}
+
+// PortConflicts returns every pair of services on the same IP of a server that open overlapping ports, sorted by their path
+// ServicesPassive and ServicesAcquirable are both checked, a passive service would open a port that an acquirable service restricts
+// our ports are read from the literal protocol and ports of structured rules, otherwise from Service.Port with any protocol
+// outbound rules don't open a port and a service without a port can't conflict
+// IPs are compared by their address, so every way of writing an IP is the same IP
+// conflicts aren't validation errors, a firewall with conflicts can be built
+func (self *Firewall) PortConflicts() Firewall_Errors {
+	if self == nil {
+		return nil
+	}
+	var errs Firewall_Errors
+	for name, server := range self.Servers {
+		if server == nil {
+			continue
+		}
+		// [IP][]Service
+		ips := make(map[string][]*service_ports)
+		for _, network_name := range sortedNetworks(server) {
+			network := server.Networks[network_name]
+			path := joinPath(serverPath(name), "networks", network_name)
+			// the same IP can be written differently, ie: "::1" and "0:0:0:0:0:0:0:1"
+			ip := network.IP
+			if parsed := net.ParseIP(ip); parsed != nil {
+				ip = parsed.String()
+			}
+			for _, service_name := range sortedServices(network.ServicesPassive) {
+				ips[ip] = append(ips[ip], &service_ports{
+					path:  joinPath(path, "services-passive", service_name),
+					ports: servicePorts(network.ServicesPassive[service_name]),
+				})
+			}
+			for _, service_name := range sortedServices(network.ServicesAcquirable) {
+				ips[ip] = append(ips[ip], &service_ports{
+					path:  joinPath(path, "services-acquirable", service_name),
+					ports: servicePorts(network.ServicesAcquirable[service_name]),
+				})
+			}
+		}
+		for ip, services := range ips {
+			for i, service := range services {
+				for _, service2 := range services[i+1:] {
+					if conflict := portConflict(service.ports, service2.ports); conflict != "" {
+						errs = append(errs, newError(service2.path, "port %s on %s conflicts with %s", conflict, ip, service.path))
+					}
+				}
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Sort(errs)
+	return errs
+}
+
+// portConflict returns the first overlap of ports and ports2, ie: "22/tcp" or "8000-8100"
+// an empty string is returned if they don't overlap
+func portConflict(
+	ports []*service_port,
+	ports2 []*service_port,
+) string {
+	for _, port := range ports {
+		for _, port2 := range ports2 {
+			if !port.overlaps(port2) {
+				continue
+			}
+			from, to := port.from, port.to
+			if port2.from > from {
+				from = port2.from
+			}
+			if port2.to < to {
+				to = port2.to
+			}
+			conflict := fmt.Sprintf("%d", from)
+			if from != to {
+				conflict = fmt.Sprintf("%d-%d", from, to)
+			}
+			protocol := port.protocol
+			if protocol == "" {
+				protocol = port2.protocol
+			}
+			if protocol != "" {
+				conflict = fmt.Sprintf("%s/%s", conflict, protocol)
+			}
+			return conflict
+		}
+	}
+	return ""
+}
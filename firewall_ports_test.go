@@ -0,0 +1,126 @@
+package firewall
+
+import (
+	"fmt"
+	"github.com/sabey/unittest"
+	"strings"
+	"testing"
+)
+
+func TestFirewallPortConflicts(t *testing.T) {
+	fmt.Println("TestFirewallPortConflicts")
+	raw := []*Firewall_Rule{
+		&Firewall_Rule{
+			Rule: "# {{.ServiceName}}",
+		},
+	}
+	structured := func(protocol string, ports string) []*Firewall_Rule {
+		return []*Firewall_Rule{
+			&Firewall_Rule{
+				Protocol: protocol,
+				Ports:    ports,
+			},
+		}
+	}
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers: map[string]*Server{
+			"Web": &Server{
+				Hostname: "web",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.0.1",
+						ServicesPassive: map[string]*Service{
+							"ssh": &Service{
+								Port:          22,
+								FirewallRules: raw,
+							},
+							"dns": &Service{
+								Port:          53,
+								FirewallRules: structured("udp", "{{.Service.Port}}"),
+							},
+							"apps": &Service{
+								FirewallRules: structured("tcp", "8000-8100"),
+							},
+						},
+						ServicesAcquirable: map[string]*Service{
+							"ssh": &Service{
+								Port:          22,
+								FirewallRules: structured("tcp", "{{.Service.Port}}"),
+							},
+							// our protocols don't overlap
+							"dns-tcp": &Service{
+								Port:          53,
+								FirewallRules: structured("tcp", "53"),
+							},
+							"admin": &Service{
+								Port:          8080,
+								FirewallRules: structured("tcp", "{{.Service.Port}}"),
+							},
+						},
+					},
+					// the same IP as lan
+					"alias": &Network{
+						IP: "10.0.0.1",
+						ServicesPassive: map[string]*Service{
+							"web": &Service{
+								FirewallRules: structured("tcp", "80,8050:8200"),
+							},
+						},
+					},
+					// another IP doesn't conflict
+					"vpn": &Network{
+						IP: "10.1.0.1",
+						ServicesPassive: map[string]*Service{
+							"ssh": &Service{
+								Port:          22,
+								FirewallRules: raw,
+							},
+						},
+					},
+				},
+			},
+			"DB": &Server{
+				Hostname: "db",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "fd00::1",
+						ServicesPassive: map[string]*Service{
+							"mysql": &Service{
+								Port:          3306,
+								FirewallRules: raw,
+							},
+						},
+					},
+					// the same IP as lan, written in full
+					"alias": &Network{
+						IP: "fd00:0:0:0:0:0:0:1",
+						ServicesAcquirable: map[string]*Service{
+							"mysql": &Service{
+								Port:          3306,
+								FirewallRules: structured("tcp", "3306"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	conflicts := fw.PortConflicts()
+	unittest.Equals(t, conflicts.Error(), strings.Join([]string{
+		"servers.DB.networks.lan.services-passive.mysql: port 3306/tcp on fd00::1 conflicts with servers.DB.networks.alias.services-acquirable.mysql",
+		"servers.Web.networks.lan.services-acquirable.admin: port 8080/tcp on 10.0.0.1 conflicts with servers.Web.networks.alias.services-passive.web",
+		"servers.Web.networks.lan.services-acquirable.admin: port 8080/tcp on 10.0.0.1 conflicts with servers.Web.networks.lan.services-passive.apps",
+		"servers.Web.networks.lan.services-acquirable.ssh: port 22/tcp on 10.0.0.1 conflicts with servers.Web.networks.lan.services-passive.ssh",
+		"servers.Web.networks.lan.services-passive.apps: port 8050-8100/tcp on 10.0.0.1 conflicts with servers.Web.networks.alias.services-passive.web",
+	}, "\n"))
+	// conflicts aren't validation errors
+	unittest.IsNil(t, fw.Validate())
+
+	// outbound rules don't open a port
+	fw.Servers["Web"].Networks["lan"].ServicesPassive["apps"].FirewallRules[0].Direction = RULE_DIRECTION_OUT
+	fw.Servers["Web"].Networks["lan"].ServicesAcquirable["admin"].Port = 9000
+	fw.Servers["Web"].Networks["lan"].ServicesAcquirable["ssh"].Port = 2222
+	fw.Servers["DB"].Networks["alias"].IP = "fd00::2"
+	unittest.IsNil(t, fw.PortConflicts())
+}
@@ -0,0 +1,126 @@
+package firewall
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// Firewall_Renderer renders the firewall of a single server
+// every renderer receives the same plan that is built by `buildFirewall`
+// renderers are registered by their firewall type, see `RegisterRenderer`
+type Firewall_Renderer interface {
+	// returned files are written to "settings.BuildPath/firewall/firewall"
+	// [Filename]Contents
+	Render(plan *Firewall_Plan) (map[string][]byte, error)
+}
+
+type firewall_renderer struct {
+	Name     string
+	Renderer Firewall_Renderer
+}
+
+var (
+	renderers_mutex sync.RWMutex
+	// [FirewallType]Renderer
+	renderers = make(map[int]*firewall_renderer)
+)
+
+func init() {
+	RegisterRenderer(FIREWALL_IPTABLES, "iptables", renderer_iptables{})
+	RegisterRenderer(FIREWALL_NFTABLES, "nftables", renderer_nftables{})
+}
+
+// RegisterRenderer registers a renderer under a firewall type id and name
+// a firewall type and name can only be registered once
+func RegisterRenderer(
+	firewall_type int,
+	name string,
+	renderer Firewall_Renderer,
+) error {
+	if firewall_type < 1 {
+		return fmt.Errorf("RegisterRenderer(%d): firewall type must be greater than 0", firewall_type)
+	}
+	if name == "" {
+		return fmt.Errorf("RegisterRenderer(%d): name empty", firewall_type)
+	}
+	if renderer == nil {
+		return fmt.Errorf("RegisterRenderer(%d): renderer nil", firewall_type)
+	}
+	renderers_mutex.Lock()
+	defer renderers_mutex.Unlock()
+	if r, ok := renderers[firewall_type]; ok {
+		return fmt.Errorf("RegisterRenderer(%d): firewall type already registered as: \"%s\"", firewall_type, r.Name)
+	}
+	for t, r := range renderers {
+		if r.Name == name {
+			return fmt.Errorf("RegisterRenderer(%d): name: \"%s\" already registered as firewall type: %d", firewall_type, name, t)
+		}
+	}
+	renderers[firewall_type] = &firewall_renderer{
+		Name:     name,
+		Renderer: renderer,
+	}
+	return nil
+}
+
+// GetRenderer returns nil if the firewall type isn't registered
+func GetRenderer(
+	firewall_type int,
+) Firewall_Renderer {
+	renderers_mutex.RLock()
+	defer renderers_mutex.RUnlock()
+	if r, ok := renderers[firewall_type]; ok {
+		return r.Renderer
+	}
+	return nil
+}
+
+// FirewallTypeByName returns 0 if the name isn't registered
+func FirewallTypeByName(
+	name string,
+) int {
+	renderers_mutex.RLock()
+	defer renderers_mutex.RUnlock()
+	for t, r := range renderers {
+		if r.Name == name {
+			return t
+		}
+	}
+	return 0
+}
+
+// FirewallTypes returns every registered firewall type
+// [FirewallType]Name
+func FirewallTypes() map[int]string {
+	renderers_mutex.RLock()
+	defer renderers_mutex.RUnlock()
+	types := make(map[int]string, len(renderers))
+	for t, r := range renderers {
+		types[t] = r.Name
+	}
+	return types
+}
+
+func (self *Firewall) renderFirewall(
+	fw *Firewall_Plan,
+) (map[string][]byte, error) {
+	renderer := GetRenderer(self.FirewallType)
+	if renderer == nil {
+		return nil, fmt.Errorf("unknown firewall type: %d", self.FirewallType)
+	}
+	files, err := renderer.Render(fw)
+	if err != nil {
+		return nil, err
+	}
+	// we're only going to allow files to be written directly into our firewall folder
+	for file, _ := range files {
+		if file == "" ||
+			file == "." ||
+			file == ".." ||
+			file != filepath.Base(file) {
+			return nil, fmt.Errorf("renderer returned an invalid filename: \"%s\"", file)
+		}
+	}
+	return files, nil
+}
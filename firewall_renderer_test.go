@@ -0,0 +1,68 @@
+package firewall
+
+import (
+	"fmt"
+	"github.com/sabey/unittest"
+	"io/ioutil"
+	"testing"
+)
+
+type renderer_test struct{}
+
+func (self renderer_test) Render(
+	fw *Firewall_Plan,
+) (map[string][]byte, error) {
+	return map[string][]byte{
+		fmt.Sprintf("%s.test", fw.ServerName): []byte(fmt.Sprintf("%s %v\n", fw.ServerName, fw.IPs)),
+	}, nil
+}
+
+// unregisterRenderer removes a firewall type registered by a test, so our registry is unchanged on the next run
+func unregisterRenderer(
+	firewall_type int,
+) {
+	renderers_mutex.Lock()
+	defer renderers_mutex.Unlock()
+	delete(renderers, firewall_type)
+}
+
+func TestRegisterRenderer(t *testing.T) {
+	fmt.Println("TestRegisterRenderer")
+	unittest.Equals(t, FirewallTypeByName("iptables"), FIREWALL_IPTABLES)
+	unittest.Equals(t, FirewallTypeByName("nftables"), FIREWALL_NFTABLES)
+	unittest.Equals(t, FirewallTypeByName("test"), 0)
+	// built in types can't be replaced
+	unittest.NotNil(t, RegisterRenderer(FIREWALL_IPTABLES, "test", renderer_test{}))
+	unittest.NotNil(t, RegisterRenderer(100, "iptables", renderer_test{}))
+	unittest.NotNil(t, RegisterRenderer(0, "test", renderer_test{}))
+	unittest.NotNil(t, RegisterRenderer(100, "test", nil))
+	fw := &Firewall{
+		FirewallType: 100,
+		Servers: map[string]*Server{
+			"Test": &Server{
+				Hostname: "test",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "192.168.1.50",
+					},
+				},
+			},
+		},
+	}
+	// not registered yet
+	unittest.Equals(t, fw.IsValid(), false)
+	unittest.IsNil(t, RegisterRenderer(100, "test", renderer_test{}))
+	t.Cleanup(func() {
+		unregisterRenderer(100)
+	})
+	unittest.Equals(t, FirewallTypeByName("test"), 100)
+	unittest.Equals(t, FirewallTypes()[100], "test")
+	unittest.Equals(t, fw.IsValid(), true)
+	settings := &Settings{
+		BuildPath: "unittest",
+	}
+	unittest.Equals(t, fw.BuildServer(settings, "Test"), true)
+	bs, err := ioutil.ReadFile(fmt.Sprintf("%s/Test.test", fw.pathFirewall(settings)))
+	unittest.IsNil(t, err)
+	unittest.Equals(t, string(bs), "Test [192.168.1.50]\n")
+}
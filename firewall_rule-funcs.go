@@ -0,0 +1,387 @@
+package firewall
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+var (
+	template_funcs_mutex sync.RWMutex
+	// functions registered by RegisterTemplateFunc
+	template_funcs = make(template.FuncMap)
+	// incremented on every registration so our cached templates are compiled again
+	template_funcs_generation = 0
+)
+
+// builtinTemplateFuncs are available in every rule template
+func builtinTemplateFuncs() template.FuncMap {
+	funcs := template.FuncMap{
+		"default":   templateDefault,
+		"join":      templateJoin,
+		"upper":     strings.ToUpper,
+		"cidr":      templateCIDR,
+		"ipFamily":  ipFamily,
+		"isIPv6":    templateIsIPv6,
+		"contains":  templateContains,
+		"required":  templateRequired,
+		"portRange": templatePortRange,
+	}
+	// lookups and params are bound when a rule is executed, see `Firewall_Rule.execute`
+	for name, fn := range templateBoundFuncs(nil, nil) {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// RegisterTemplateFunc makes fn available to every rule template as name
+// built in functions can't be replaced and a name can only be registered once
+// fn must return a single value, or a value and an error
+func RegisterTemplateFunc(
+	name string,
+	fn interface{},
+) (err error) {
+	if name == "" {
+		return fmt.Errorf("RegisterTemplateFunc: name is empty")
+	}
+	if _, ok := builtinTemplateFuncs()[name]; ok {
+		return fmt.Errorf("RegisterTemplateFunc: \"%s\" is a built in function", name)
+	}
+	// template panics on invalid functions
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("RegisterTemplateFunc: \"%s\": %v", name, r)
+		}
+	}()
+	template.New("rule").Funcs(template.FuncMap{name: fn})
+	template_funcs_mutex.Lock()
+	defer template_funcs_mutex.Unlock()
+	if _, ok := template_funcs[name]; ok {
+		return fmt.Errorf("RegisterTemplateFunc: \"%s\" is already registered", name)
+	}
+	template_funcs[name] = fn
+	template_funcs_generation++
+	return nil
+}
+
+// templateFuncs returns every built in and registered function and the generation of our registry
+func templateFuncs() (template.FuncMap, int) {
+	funcs := builtinTemplateFuncs()
+	template_funcs_mutex.RLock()
+	defer template_funcs_mutex.RUnlock()
+	for name, fn := range template_funcs {
+		funcs[name] = fn
+	}
+	return funcs, template_funcs_generation
+}
+func templateGeneration() int {
+	template_funcs_mutex.RLock()
+	defer template_funcs_mutex.RUnlock()
+	return template_funcs_generation
+}
+
+// templateEmpty is true for nil, zero values and empty strings, slices and maps
+func templateEmpty(
+	value interface{},
+) bool {
+	if value == nil {
+		return true
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return v.IsZero()
+}
+
+// {{index .Network.Vars "interface" | default "eth0"}}
+// index returns nil for a missing key, where .Network.Vars.interface fails
+func templateDefault(
+	def interface{},
+	value interface{},
+) interface{} {
+	if templateEmpty(value) {
+		return def
+	}
+	return value
+}
+
+// {{.Server.Vars.ips | join ","}}
+func templateJoin(
+	sep string,
+	list interface{},
+) (string, error) {
+	if s, ok := list.([]string); ok {
+		return strings.Join(s, sep), nil
+	}
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice &&
+		v.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: %T is not a list", list)
+	}
+	s := make([]string, v.Len())
+	for i := 0; i < v.Len(); i++ {
+		s[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(s, sep), nil
+}
+
+// {{cidr .Network.IP 24}} = "192.168.1.0/24"
+func templateCIDR(
+	ip string,
+	bits int,
+) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("cidr: \"%s\" is not an IP", ip)
+	}
+	size := 128
+	if parsed.To4() != nil {
+		parsed = parsed.To4()
+		size = 32
+	}
+	if bits < 0 || bits > size {
+		return "", fmt.Errorf("cidr: %d bits is invalid for \"%s\"", bits, ip)
+	}
+	mask := net.CIDRMask(bits, size)
+	return (&net.IPNet{
+		IP:   parsed.Mask(mask),
+		Mask: mask,
+	}).String(), nil
+}
+
+// {{if isIPv6 .SourceNetwork.IP}}
+func templateIsIPv6(
+	ip string,
+) bool {
+	return ipFamily(ip) == FAMILY_IPV6
+}
+
+// {{if contains "lan" .NetworkName}} or {{if contains "web" .Server.Vars.roles}}
+// strings are searched for a substring, lists for an element and maps for a key
+func templateContains(
+	needle interface{},
+	haystack interface{},
+) (bool, error) {
+	if s, ok := haystack.(string); ok {
+		return strings.Contains(s, fmt.Sprint(needle)), nil
+	}
+	v := reflect.ValueOf(haystack)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if reflect.DeepEqual(v.Index(i).Interface(), needle) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			if reflect.DeepEqual(key.Interface(), needle) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("contains: can't search %T", haystack)
+}
+
+// {{required "an interface is required" (index .Network.Vars "interface")}}
+func templateRequired(
+	msg string,
+	value interface{},
+) (interface{}, error) {
+	if templateEmpty(value) {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return value, nil
+}
+
+// {{portRange ":" "8000-8100"}} = "8000:8100"
+// a single port is returned as is
+func templatePortRange(
+	sep string,
+	ports interface{},
+) (string, error) {
+	port, err := parsePortRange(strings.TrimSpace(fmt.Sprint(ports)))
+	if err != nil {
+		return "", fmt.Errorf("portRange: %w", err)
+	}
+	return strings.Replace(port, "-", sep, 1), nil
+}
+
+// templateLookupFuncs resolve names against Firewall.Servers
+// an unknown name fails the same way a missing key does
+func (self *Firewall) templateLookupFuncs() template.FuncMap {
+	network := func(
+		fn string,
+		server_name string,
+		network_name string,
+	) (*Network, error) {
+		if self == nil {
+			return nil, fmt.Errorf("%s: no Firewall to look up server: \"%s\"", fn, server_name)
+		}
+		server, ok := self.Servers[server_name]
+		if !ok || server == nil {
+			return nil, fmt.Errorf("%s: server: \"%s\" not found", fn, server_name)
+		}
+		n, ok := server.Networks[network_name]
+		if !ok || n == nil {
+			return nil, fmt.Errorf("%s: server: \"%s\" network: \"%s\" not found", fn, server_name, network_name)
+		}
+		return n, nil
+	}
+	return template.FuncMap{
+		// {{serverIP "Monitor" "lan"}}
+		"serverIP": func(
+			server_name string,
+			network_name string,
+		) (string, error) {
+			n, err := network("serverIP", server_name, network_name)
+			if err != nil {
+				return "", err
+			}
+			return n.IP, nil
+		},
+		// {{servicePort "MediaServer" "lan" "mysql"}}
+		// acquirable services are searched before passive services
+		"servicePort": func(
+			server_name string,
+			network_name string,
+			service_name string,
+		) (uint16, error) {
+			n, err := network("servicePort", server_name, network_name)
+			if err != nil {
+				return 0, err
+			}
+			if service, ok := n.ServicesAcquirable[service_name]; ok && service != nil {
+				return service.Port, nil
+			}
+			if service, ok := n.ServicesPassive[service_name]; ok && service != nil {
+				return service.Port, nil
+			}
+			return 0, fmt.Errorf("servicePort: server: \"%s\" network: \"%s\" service: \"%s\" not found", server_name, network_name, service_name)
+		},
+		// {{serverVar "Monitor" "key"}}
+		"serverVar": func(
+			server_name string,
+			key string,
+		) (interface{}, error) {
+			if self == nil {
+				return nil, fmt.Errorf("serverVar: no Firewall to look up server: \"%s\"", server_name)
+			}
+			server, ok := self.Servers[server_name]
+			if !ok || server == nil {
+				return nil, fmt.Errorf("serverVar: server: \"%s\" not found", server_name)
+			}
+			value, ok := server.Vars[key]
+			if !ok {
+				return nil, fmt.Errorf("serverVar: server: \"%s\" has no entry for key \"%s\"", server_name, key)
+			}
+			return value, nil
+		},
+	}
+}
+
+// templateBoundFuncs are our lookup functions bound to fw and param bound to the params of a snippet
+func templateBoundFuncs(
+	fw *Firewall,
+	params map[string]interface{},
+) template.FuncMap {
+	funcs := fw.templateLookupFuncs()
+	// {{param "port"}}
+	funcs["param"] = func(
+		name string,
+	) (interface{}, error) {
+		value, ok := params[name]
+		if !ok {
+			return nil, fmt.Errorf("param: \"%s\" not set", name)
+		}
+		return value, nil
+	}
+	return funcs
+}
+
+// templateBound is true if t calls one of our bound functions
+// it's checked once when our template is compiled, so templates that don't are executed without a copy
+func templateBound(
+	t *template.Template,
+) bool {
+	bound := templateBoundFuncs(nil, nil)
+	for _, t := range t.Templates() {
+		if t.Tree != nil &&
+			templateCalls(t.Tree.Root, bound) {
+			return true
+		}
+	}
+	return false
+}
+
+// templateCalls is true if node calls any function of funcs
+func templateCalls(
+	node parse.Node,
+	funcs template.FuncMap,
+) bool {
+	nodes := []parse.Node{}
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node != nil {
+			nodes = append(nodes, node.Nodes...)
+		}
+	case *parse.ActionNode:
+		nodes = append(nodes, node.Pipe)
+	case *parse.IfNode:
+		nodes = append(nodes, node.Pipe, node.List, node.ElseList)
+	case *parse.RangeNode:
+		nodes = append(nodes, node.Pipe, node.List, node.ElseList)
+	case *parse.WithNode:
+		nodes = append(nodes, node.Pipe, node.List, node.ElseList)
+	case *parse.TemplateNode:
+		nodes = append(nodes, node.Pipe)
+	case *parse.PipeNode:
+		if node != nil {
+			for _, cmd := range node.Cmds {
+				nodes = append(nodes, cmd)
+			}
+		}
+	case *parse.CommandNode:
+		nodes = append(nodes, node.Args...)
+	case *parse.ChainNode:
+		nodes = append(nodes, node.Node)
+	case *parse.IdentifierNode:
+		_, ok := funcs[node.Ident]
+		return ok
+	}
+	for _, node := range nodes {
+		if templateCalls(node, funcs) {
+			return true
+		}
+	}
+	return false
+}
+
+// variablesFirewall returns the Firewall of a Firewall_Variables_* object
+func variablesFirewall(
+	vars interface{},
+) (*Firewall, error) {
+	switch vars := vars.(type) {
+	case *Firewall_Variables_Server:
+		return vars.Firewall, nil
+	case *Firewall_Variables_Network:
+		return vars.Firewall, nil
+	case *Firewall_Variables_Service_Passive:
+		return vars.Firewall, nil
+	case *Firewall_Variables_Service_Acquirable:
+		return vars.Firewall, nil
+	case *Firewall_Variables_Service_Dependencies:
+		return vars.Firewall, nil
+	}
+	return nil, fmt.Errorf("unknown variables: %T", vars)
+}
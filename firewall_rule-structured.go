@@ -0,0 +1,201 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	RULE_DIRECTION_IN      = "in"
+	RULE_DIRECTION_OUT     = "out"
+	RULE_DIRECTION_FORWARD = "forward"
+	RULE_ACTION_ACCEPT     = "accept"
+	RULE_ACTION_DROP       = "drop"
+	RULE_ACTION_REJECT     = "reject"
+	RULE_ACTION_LOG        = "log"
+)
+
+// Firewall_Rule_Structured is a structured Firewall_Rule after templating
+// every firewall type renders this in its own syntax
+type Firewall_Rule_Structured struct {
+	Direction string
+	Protocol  string
+	// individual ports or ranges, ie: "22" or "8000-8100"
+	Ports       []string
+	Source      string
+	Destination string
+	Interface   string
+	State       []string
+	Action      string
+	// IP Family the rule is being rendered for, 0 if our variables don't have one
+	Family int
+}
+
+// IsStructured returns true if our rule is built from its structured fields instead of Rule
+func (self *Firewall_Rule) IsStructured() bool {
+	return self.Rule == "" && self.hasStructured()
+}
+func (self *Firewall_Rule) hasStructured() bool {
+	return self.Direction != "" ||
+		self.Protocol != "" ||
+		self.Ports != "" ||
+		self.Source != "" ||
+		self.Destination != "" ||
+		self.Interface != "" ||
+		self.State != "" ||
+		self.Action != ""
+}
+
+// Resolve templates our structured fields with a Firewall_Variables_* object
+func (self *Firewall_Rule) Resolve(
+	vars interface{},
+) (*Firewall_Rule_Structured, error) {
+	return self.resolve(vars, nil)
+}
+func (self *Firewall_Rule) resolve(
+	vars interface{},
+	params map[string]interface{},
+) (*Firewall_Rule_Structured, error) {
+	if !self.IsStructured() {
+		return nil, fmt.Errorf("Firewall_Rule.Resolve rule is not structured")
+	}
+	// skip our raw rule
+	fields := []string{}
+	for _, field := range self.fields()[1:] {
+		if !strings.Contains(field.text, "{{") {
+			fields = append(fields, field.text)
+			continue
+		}
+		buff := &bytes.Buffer{}
+		if err := self.execute(buff, field.name, field.text, vars, params); err != nil {
+			return nil, err
+		}
+		fields = append(fields, buff.String())
+	}
+	s := &Firewall_Rule_Structured{
+		Direction:   strings.ToLower(strings.TrimSpace(fields[0])),
+		Protocol:    strings.ToLower(strings.TrimSpace(fields[1])),
+		Source:      strings.TrimSpace(fields[3]),
+		Destination: strings.TrimSpace(fields[4]),
+		Interface:   strings.TrimSpace(fields[5]),
+		Action:      strings.ToLower(strings.TrimSpace(fields[7])),
+		Family:      variablesFamily(vars),
+	}
+	if s.Direction == "" {
+		s.Direction = RULE_DIRECTION_IN
+	}
+	if s.Action == "" {
+		s.Action = RULE_ACTION_ACCEPT
+	}
+	for _, port := range splitList(fields[2]) {
+		port, err := parsePortRange(port)
+		if err != nil {
+			return nil, err
+		}
+		s.Ports = append(s.Ports, port)
+	}
+	for _, state := range splitList(fields[6]) {
+		s.State = append(s.State, strings.ToLower(state))
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+func (self *Firewall_Rule_Structured) check() error {
+	switch self.Direction {
+	case RULE_DIRECTION_IN, RULE_DIRECTION_OUT, RULE_DIRECTION_FORWARD:
+	default:
+		return fmt.Errorf("Firewall_Rule.Direction: \"%s\" invalid", self.Direction)
+	}
+	switch self.Action {
+	case RULE_ACTION_ACCEPT, RULE_ACTION_DROP, RULE_ACTION_REJECT, RULE_ACTION_LOG:
+	default:
+		return fmt.Errorf("Firewall_Rule.Action: \"%s\" invalid", self.Action)
+	}
+	if len(self.Ports) > 0 &&
+		self.Protocol != "tcp" &&
+		self.Protocol != "udp" {
+		return fmt.Errorf("Firewall_Rule.Ports require protocol tcp or udp")
+	}
+	for _, state := range self.State {
+		switch state {
+		case "new", "established", "related", "invalid":
+		default:
+			return fmt.Errorf("Firewall_Rule.State: \"%s\" invalid", state)
+		}
+	}
+	return nil
+}
+
+// family returns our IP Family, if it's unknown it's read from our source or destination
+func (self *Firewall_Rule_Structured) family() int {
+	if self.Family != 0 {
+		return self.Family
+	}
+	for _, address := range []string{self.Source, self.Destination} {
+		if i := strings.Index(address, "/"); i > -1 {
+			address = address[:i]
+		}
+		if family := ipFamily(address); family != 0 {
+			return family
+		}
+	}
+	return 0
+}
+
+// variablesFamily returns the IP Family of a Firewall_Variables_* object
+func variablesFamily(
+	vars interface{},
+) int {
+	switch vars := vars.(type) {
+	case *Firewall_Variables_Server:
+		return vars.Family
+	case *Firewall_Variables_Network:
+		return vars.Family
+	case *Firewall_Variables_Service_Passive:
+		return vars.Family
+	case *Firewall_Variables_Service_Acquirable:
+		return vars.Family
+	case *Firewall_Variables_Service_Dependencies:
+		return vars.Family
+	}
+	return 0
+}
+
+// splitList splits a comma or space separated list
+func splitList(
+	list string,
+) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// parsePortRange returns "22" or "8000-8100", ranges may also be written as "8000:8100"
+func parsePortRange(
+	port string,
+) (string, error) {
+	// empty ports are invalid, ie: "-5", "5-" or "80--90"
+	ports := strings.Split(strings.ReplaceAll(port, ":", "-"), "-")
+	if len(ports) > 2 {
+		return "", fmt.Errorf("Firewall_Rule.Ports: \"%s\" invalid", port)
+	}
+	var parsed []uint64
+	for _, p := range ports {
+		i, err := strconv.ParseUint(p, 10, 16)
+		if err != nil || i < 1 {
+			return "", fmt.Errorf("Firewall_Rule.Ports: \"%s\" invalid", port)
+		}
+		parsed = append(parsed, i)
+	}
+	if len(parsed) == 1 {
+		return fmt.Sprintf("%d", parsed[0]), nil
+	}
+	if parsed[0] >= parsed[1] {
+		return "", fmt.Errorf("Firewall_Rule.Ports: \"%s\" range invalid", port)
+	}
+	return fmt.Sprintf("%d-%d", parsed[0], parsed[1]), nil
+}
@@ -1,93 +1,289 @@
 package firewall
 
 import (
+	"fmt"
 	"io"
 	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
+	"unsafe"
 )
 
 type Firewall_Rule struct {
+	// Raw Rule
+	// the rule is written as is, so it's only understood by a single firewall type
 	Rule string `json:"rule,omitempty"`
+	// Structured Rule
+	// if Rule is empty, each firewall type will write the rule from these fields in its own syntax
+	// every field supports templating
+	// "in", "out" or "forward", defaults to "in"
+	Direction string `json:"direction,omitempty"`
+	// "tcp", "udp", "icmp"
+	Protocol string `json:"protocol,omitempty"`
+	// Destination Ports
+	// comma separated ports or ranges, ie: "80,443" or "8000-8100", requires tcp or udp
+	Ports string `json:"ports,omitempty"`
+	// Source and Destination IPs or CIDRs
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	// the in interface, or the out interface if our direction is "out"
+	Interface string `json:"interface,omitempty"`
+	// comma separated connection states, ie: "established,related"
+	State string `json:"state,omitempty"`
+	// "accept", "drop", "reject" or "log", defaults to "accept"
+	Action string `json:"action,omitempty"`
+	// Snippet
+	// the name of a rule in Firewall.Snippets, written in place of this rule with our context
+	// can't be combined with a raw or structured rule
+	Snippet string `json:"snippet,omitempty"`
+	// optional parameters of our snippet, read with {{param "name"}}
+	Params map[string]interface{} `json:"params,omitempty"`
+	// compiled templates of our fields, see `compile`
+	// a *firewall_rule_cache created on first use without a lock, see `templateCache`
+	// a copy of our rule shares this cache, which is safe since templates are cached by their text
+	cache unsafe.Pointer
+}
+
+type firewall_rule_cache struct {
+	// only held to read or replace a template, templates are compiled without it
+	mutex sync.RWMutex
+	// [Field]Template
+	templates map[string]*firewall_rule_template
+}
+type firewall_rule_template struct {
+	text string
+	// generation of our registered template functions
+	generation int
+	template   *template.Template
+	// true if our template calls one of our bound functions, see `templateBound`
+	bound bool
 }
 
 func (self *Firewall_Rule) IsValid() bool {
-	if self == nil {
-		log.Println("Firewall_Rule nil")
-		return false
-	}
-	if self.Rule == "" {
-		log.Println("Firewall_Rule.Rule empty")
+	if err := self.Check(); err != nil {
+		log.Printf("Firewall_Rule invalid: %s\n", err)
 		return false
 	}
 	return true
 }
-func (self *Firewall_Rule) ParseServer(
+
+// Check returns a Firewall_Error for the first invalid attribute
+func (self *Firewall_Rule) Check() error {
+	return self.validate("").err()
+}
+func (self *Firewall_Rule) validate(
+	path string,
+) Firewall_Errors {
+	if self == nil {
+		return Firewall_Errors{newError(path, "Firewall_Rule nil")}
+	}
+	var errs Firewall_Errors
+	if self.Rule == "" && !self.IsStructured() && self.Snippet == "" {
+		errs = append(errs, newError(joinPath(path, "rule"), "empty"))
+	}
+	if self.Rule != "" && self.hasStructured() {
+		errs = append(errs, newError(joinPath(path, "rule"), "can't be combined with a structured rule"))
+	}
+	if self.Snippet != "" && (self.Rule != "" || self.hasStructured()) {
+		errs = append(errs, newError(joinPath(path, "snippet"), "can't be combined with a raw or structured rule"))
+	}
+	if self.Snippet == "" && len(self.Params) > 0 {
+		errs = append(errs, newError(joinPath(path, "params"), "requires a snippet"))
+	}
+	// report template syntax errors before we build
+	// text without an action can't fail to compile
+	for _, field := range self.fields() {
+		if !strings.Contains(field.text, "{{") {
+			continue
+		}
+		if _, err := self.compile(field.name, field.text); err != nil {
+			errs = append(errs, &Firewall_Error{
+				Path: joinPath(path, field.name),
+				Err:  err,
+			})
+		}
+	}
+	return errs
+}
+
+// fields returns every templated field of our rule by its json attribute name
+// structured fields are in the order of Firewall_Rule_Structured
+func (self *Firewall_Rule) fields() []*firewall_rule_field {
+	return []*firewall_rule_field{
+		&firewall_rule_field{"rule", self.Rule},
+		&firewall_rule_field{"direction", self.Direction},
+		&firewall_rule_field{"protocol", self.Protocol},
+		&firewall_rule_field{"ports", self.Ports},
+		&firewall_rule_field{"source", self.Source},
+		&firewall_rule_field{"destination", self.Destination},
+		&firewall_rule_field{"interface", self.Interface},
+		&firewall_rule_field{"state", self.State},
+		&firewall_rule_field{"action", self.Action},
+	}
+}
+
+type firewall_rule_field struct {
+	name string
+	text string
+}
+
+// parse writes a raw rule, or a structured rule with the format of our firewall type
+// a snippet is written in place of our rule
+func (self *Firewall_Rule) parse(
 	w io.Writer,
-	vars *Firewall_Variables_Server,
+	vars interface{},
+	format firewall_rule_format,
 ) error {
-	t, err := template.New("rule").Parse(self.Rule)
+	if self.Snippet == "" {
+		return self.write(w, vars, format, nil)
+	}
+	fw, err := variablesFirewall(vars)
+	if err != nil {
+		return fmt.Errorf("Firewall_Rule.parse %w", err)
+	}
+	snippet, err := fw.snippet(self.Snippet)
 	if err != nil {
-		// rule failed
-		log.Printf("Firewall_Rule.ParseServer failed to parse: \"%s\"\n", err)
 		return err
 	}
-	// WE MUST FAIL ON ANY TEMPLATE ERROR!!!
-	t.Option("missingkey=error")
-	return t.Execute(w, vars)
+	return snippet.write(w, vars, format, self.Params)
 }
-func (self *Firewall_Rule) ParseNetwork(
+func (self *Firewall_Rule) write(
 	w io.Writer,
-	vars *Firewall_Variables_Network,
+	vars interface{},
+	format firewall_rule_format,
+	params map[string]interface{},
 ) error {
-	t, err := template.New("rule").Parse(self.Rule)
-	if err != nil {
-		// rule failed
-		log.Printf("Firewall_Rule.ParseNetwork failed to parse: \"%s\"\n", err)
+	if self.IsStructured() {
+		if format == nil {
+			return fmt.Errorf("Firewall_Rule.parse structured rules require a firewall type")
+		}
+		s, err := self.resolve(vars, params)
+		if err != nil {
+			return err
+		}
+		rule, err := format(s)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, rule)
 		return err
 	}
-	// WE MUST FAIL ON ANY TEMPLATE ERROR!!!
-	t.Option("missingkey=error")
-	return t.Execute(w, vars)
+	if _, err := variablesFirewall(vars); err != nil {
+		return fmt.Errorf("Firewall_Rule.Parse %w", err)
+	}
+	return self.execute(w, "rule", self.Rule, vars, params)
+}
+
+// Parse writes our raw rule templated with a Firewall_Variables_* object
+// a snippet is written in place of our rule, structured rules require a firewall type and can't be parsed
+func (self *Firewall_Rule) Parse(
+	w io.Writer,
+	vars interface{},
+) error {
+	return self.parse(w, vars, nil)
+}
+func (self *Firewall_Rule) ParseServer(
+	w io.Writer,
+	vars *Firewall_Variables_Server,
+) error {
+	return self.Parse(w, vars)
+}
+func (self *Firewall_Rule) ParseNetwork(
+	w io.Writer,
+	vars *Firewall_Variables_Network,
+) error {
+	return self.Parse(w, vars)
 }
 func (self *Firewall_Rule) ParseServicePassive(
 	w io.Writer,
 	vars *Firewall_Variables_Service_Passive,
 ) error {
-	t, err := template.New("rule").Parse(self.Rule)
-	if err != nil {
-		// rule failed
-		log.Printf("Firewall_Rule.ParseServicePassive failed to parse: \"%s\"\n", err)
-		return err
-	}
-	// WE MUST FAIL ON ANY TEMPLATE ERROR!!!
-	t.Option("missingkey=error")
-	return t.Execute(w, vars)
+	return self.Parse(w, vars)
 }
 func (self *Firewall_Rule) ParseServiceAcquirable(
 	w io.Writer,
 	vars *Firewall_Variables_Service_Acquirable,
 ) error {
-	t, err := template.New("rule").Parse(self.Rule)
-	if err != nil {
-		// rule failed
-		log.Printf("Firewall_Rule.ParseServiceAcquirable failed to parse: \"%s\"\n", err)
-		return err
-	}
-	// WE MUST FAIL ON ANY TEMPLATE ERROR!!!
-	t.Option("missingkey=error")
-	return t.Execute(w, vars)
+	return self.Parse(w, vars)
 }
 func (self *Firewall_Rule) ParseServiceDependencies(
 	w io.Writer,
 	vars *Firewall_Variables_Service_Dependencies,
 ) error {
-	t, err := template.New("rule").Parse(self.Rule)
+	return self.Parse(w, vars)
+}
+
+// execute writes a field templated with a Firewall_Variables_* object
+// our lookup functions are bound to the Firewall of vars and param to the params of our snippet
+func (self *Firewall_Rule) execute(
+	w io.Writer,
+	field string,
+	text string,
+	vars interface{},
+	params map[string]interface{},
+) error {
+	compiled, err := self.compile(field, text)
 	if err != nil {
-		// rule failed
-		log.Printf("Firewall_Rule.ParseServiceDependencies failed to parse: \"%s\"\n", err)
 		return err
 	}
-	// WE MUST FAIL ON ANY TEMPLATE ERROR!!!
-	t.Option("missingkey=error")
+	t := compiled.template
+	if compiled.bound {
+		fw, _ := variablesFirewall(vars)
+		// our cached template is shared, so we bind to a copy
+		if t, err = t.Clone(); err != nil {
+			return err
+		}
+		t.Funcs(templateBoundFuncs(fw, params))
+	}
 	return t.Execute(w, vars)
 }
+
+// compile returns the compiled template of a field
+// templates are cached and only compiled again if the text of the field or our registered functions have changed
+// compile is safe to call from multiple goroutines, if they compile the same field at once the last template is kept
+func (self *Firewall_Rule) compile(
+	field string,
+	text string,
+) (*firewall_rule_template, error) {
+	cache := self.templateCache()
+	cache.mutex.RLock()
+	t, ok := cache.templates[field]
+	cache.mutex.RUnlock()
+	if ok &&
+		t.text == text &&
+		t.generation == templateGeneration() {
+		return t, nil
+	}
+	funcs, generation := templateFuncs()
+	compiled, err := template.New("rule").Funcs(funcs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// WE MUST FAIL ON ANY TEMPLATE ERROR!!!
+	compiled.Option("missingkey=error")
+	t = &firewall_rule_template{
+		text:       text,
+		generation: generation,
+		template:   compiled,
+		bound:      templateBound(compiled),
+	}
+	cache.mutex.Lock()
+	if cache.templates == nil {
+		cache.templates = make(map[string]*firewall_rule_template)
+	}
+	cache.templates[field] = t
+	cache.mutex.Unlock()
+	return t, nil
+}
+
+// templateCache returns our cache, it's created on first use
+// if multiple goroutines create our cache at once only the first is kept
+func (self *Firewall_Rule) templateCache() *firewall_rule_cache {
+	if cache := atomic.LoadPointer(&self.cache); cache != nil {
+		return (*firewall_rule_cache)(cache)
+	}
+	atomic.CompareAndSwapPointer(&self.cache, nil, unsafe.Pointer(&firewall_rule_cache{}))
+	return (*firewall_rule_cache)(atomic.LoadPointer(&self.cache))
+}
@@ -0,0 +1,338 @@
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sabey/unittest"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFirewallRuleStructured(t *testing.T) {
+	fmt.Println("TestFirewallRuleStructured")
+	vars := &Firewall_Variables_Service_Acquirable{
+		ServiceName:            "mysql",
+		SourceServerName:       "MyPC",
+		SourceNetworkName:      "lan",
+		SourceNetwork:          &Network{IP: "192.168.1.13"},
+		DestinationServerName:  "MediaServer",
+		DestinationNetworkName: "lan",
+		DestinationNetwork:     &Network{IP: "192.168.1.31"},
+		DestinationService:     &Service{Port: 3306},
+	}
+	rule := &Firewall_Rule{
+		Protocol:  "tcp",
+		Ports:     "{{.DestinationService.Port}}",
+		Source:    "{{.SourceNetwork.IP}}",
+		Interface: "{{.DestinationNetworkName}}",
+	}
+	unittest.Equals(t, rule.IsValid(), true)
+	unittest.Equals(t, rule.IsStructured(), true)
+	s, err := rule.Resolve(vars)
+	unittest.IsNil(t, err)
+	unittest.Equals(t, s.Direction, RULE_DIRECTION_IN)
+	unittest.Equals(t, s.Action, RULE_ACTION_ACCEPT)
+	r, _ := formatIPTables(s)
+	unittest.Equals(t, r, "-A INPUT -i lan -p tcp --src 192.168.1.13 --dport 3306 -j ACCEPT")
+	r, _ = formatNFTables(s)
+	unittest.Equals(t, r, "add rule inet filter input iifname \"lan\" ip saddr 192.168.1.13 tcp dport 3306 accept")
+
+	rule = &Firewall_Rule{
+		Direction: "out",
+		Protocol:  "udp",
+		Ports:     "53, 8000:8100",
+		Source:    "fd00::/8",
+		State:     "new,established",
+		Action:    "drop",
+	}
+	s, err = rule.Resolve(vars)
+	unittest.IsNil(t, err)
+	r, _ = formatIPTables(s)
+	unittest.Equals(t, r, "-A OUTPUT -p udp --src fd00::/8 -m multiport --dports 53,8000:8100 -m state --state NEW,ESTABLISHED -j DROP")
+	r, _ = formatNFTables(s)
+	unittest.Equals(t, r, "add rule inet filter output ip6 saddr fd00::/8 udp dport { 53, 8000-8100 } ct state new,established drop")
+
+	// icmp is ICMPv6 for IPv6
+	rule = &Firewall_Rule{
+		Protocol: "icmp",
+	}
+	s, err = rule.Resolve(vars)
+	unittest.IsNil(t, err)
+	r, _ = formatIPTables(s)
+	unittest.Equals(t, r, "-A INPUT -p icmp -j ACCEPT")
+	r, _ = formatNFTables(s)
+	unittest.Equals(t, r, "add rule inet filter input meta l4proto icmp accept")
+	s.Family = FAMILY_IPV6
+	r, _ = formatIPTables(s)
+	unittest.Equals(t, r, "-A INPUT -p ipv6-icmp -j ACCEPT")
+	r, _ = formatNFTables(s)
+	unittest.Equals(t, r, "add rule inet filter input meta l4proto icmpv6 accept")
+	rule.Source = "fd00::/8"
+	s, err = rule.Resolve(vars)
+	unittest.IsNil(t, err)
+	r, _ = formatNFTables(s)
+	unittest.Equals(t, r, "add rule inet filter input ip6 saddr fd00::/8 meta l4proto icmpv6 accept")
+
+	// iptables multiport matches at most 15 ports, a range counts as two
+	rule = &Firewall_Rule{
+		Protocol: "tcp",
+		Ports:    "1,2,3,4,5,6,7,8,9,10,11,12,13,14,100-200",
+	}
+	s, err = rule.Resolve(vars)
+	unittest.IsNil(t, err)
+	_, err = formatIPTables(s)
+	unittest.NotNil(t, err)
+	_, err = formatNFTables(s)
+	unittest.IsNil(t, err)
+	s.Ports = s.Ports[1:]
+	_, err = formatIPTables(s)
+	unittest.IsNil(t, err)
+
+	// raw rules can't be combined with structured rules
+	unittest.Equals(t, (&Firewall_Rule{Rule: "-F", Action: "drop"}).IsValid(), false)
+	unittest.Equals(t, (&Firewall_Rule{}).IsValid(), false)
+	// invalid values fail when resolved
+	_, err = (&Firewall_Rule{Action: "allow"}).Resolve(vars)
+	unittest.NotNil(t, err)
+	_, err = (&Firewall_Rule{Ports: "22"}).Resolve(vars)
+	unittest.NotNil(t, err)
+	for _, ports := range []string{"100-10", "-5", "5-", "80--90", "80:-90", "80-90-100", "0", "65536"} {
+		_, err = (&Firewall_Rule{Protocol: "tcp", Ports: ports}).Resolve(vars)
+		unittest.NotNil(t, err)
+	}
+	_, err = (&Firewall_Rule{Source: "{{.Missing}}"}).Resolve(vars)
+	unittest.NotNil(t, err)
+}
+func TestFirewallRuleTemplate(t *testing.T) {
+	fmt.Println("TestFirewallRuleTemplate")
+	vars := &Firewall_Variables_Server{
+		ServerName: "MyPC",
+		Server: &Server{
+			Hostname: "mypc",
+		},
+	}
+	rule := &Firewall_Rule{
+		Rule: "# {{.ServerName}}",
+	}
+	// templates are compiled once
+	first, err := rule.compile("rule", rule.Rule)
+	unittest.IsNil(t, err)
+	second, err := rule.compile("rule", rule.Rule)
+	unittest.IsNil(t, err)
+	unittest.Equals(t, first == second, true)
+	// and compiled again once our text changes
+	rule.Rule = "# {{.Server.Hostname}}"
+	second, err = rule.compile("rule", rule.Rule)
+	unittest.IsNil(t, err)
+	unittest.Equals(t, first == second, false)
+	// only templates that call a bound function are bound
+	unittest.Equals(t, second.bound, false)
+	bound := &Firewall_Rule{
+		Rule: "# param serverIP {{.ServerName}}",
+	}
+	compiled, err := bound.compile("rule", bound.Rule)
+	unittest.IsNil(t, err)
+	unittest.Equals(t, compiled.bound, false)
+	bound.Rule = `# {{if true}}{{serverIP "MyPC" "lan"}}{{end}}`
+	compiled, err = bound.compile("rule", bound.Rule)
+	unittest.IsNil(t, err)
+	unittest.Equals(t, compiled.bound, true)
+	// every Parse method shares the same template
+	buff := &bytes.Buffer{}
+	unittest.IsNil(t, rule.ParseServer(buff, vars))
+	unittest.Equals(t, buff.String(), "# mypc")
+	unittest.NotNil(t, rule.Parse(buff, vars.Server))
+	// templates can be used by multiple goroutines
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unittest.IsNil(t, rule.Parse(ioutil.Discard, vars))
+		}()
+	}
+	wg.Wait()
+	// a copy of our rule shares our cache, but still uses its own text
+	copied := *rule
+	copied.Rule = "# copy {{.ServerName}}"
+	buff.Reset()
+	unittest.IsNil(t, copied.ParseServer(buff, vars))
+	unittest.Equals(t, buff.String(), "# copy MyPC")
+	buff.Reset()
+	unittest.IsNil(t, rule.ParseServer(buff, vars))
+	unittest.Equals(t, buff.String(), "# mypc")
+	// our cache is created once on first use by multiple goroutines
+	rule = &Firewall_Rule{
+		Rule: "# {{.ServerName}}",
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unittest.IsNil(t, rule.Parse(ioutil.Discard, vars))
+		}()
+	}
+	wg.Wait()
+
+	// syntax errors are reported when validating
+	rule = &Firewall_Rule{
+		Rule: "# {{.ServerName",
+	}
+	err = rule.Check()
+	unittest.NotNil(t, err)
+	unittest.Equals(t, strings.HasPrefix(err.Error(), "rule: template: rule:1: "), true)
+	rule = &Firewall_Rule{
+		Protocol: "tcp",
+		Ports:    "{{.Service.Port}",
+	}
+	err = rule.Check()
+	unittest.NotNil(t, err)
+	unittest.Equals(t, strings.HasPrefix(err.Error(), "ports: template: rule:1: "), true)
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		FirewallRulesBefore: []*Firewall_Rule{
+			rule,
+		},
+	}
+	unittest.Equals(t, strings.HasPrefix(fw.Validate().Error(), "firewall-rules-before[0].ports: template: rule:1: "), true)
+}
+func TestFirewallRuleFuncs(t *testing.T) {
+	fmt.Println("TestFirewallRuleFuncs")
+	vars := &Firewall_Variables_Network{
+		ServerName:  "MyPC",
+		NetworkName: "lan",
+		Network: &Network{
+			IP: "192.168.1.13",
+			Vars: map[string]interface{}{
+				"roles": []interface{}{"web", "db"},
+			},
+		},
+	}
+	parse := func(rule string) (string, error) {
+		buff := &bytes.Buffer{}
+		err := (&Firewall_Rule{Rule: rule}).Parse(buff, vars)
+		return buff.String(), err
+	}
+	for rule, expected := range map[string]string{
+		`{{index .Network.Vars "interface" | default "eth0"}}`: "eth0",
+		`{{.NetworkName | default "eth0"}}`:                    "lan",
+		`{{.Network.Vars.roles | join ","}}`:                   "web,db",
+		`{{upper "accept"}}`:                                   "ACCEPT",
+		`{{cidr .Network.IP 24}}`:                              "192.168.1.0/24",
+		`{{cidr "fd00::60" 64}}`:                               "fd00::/64",
+		`{{ipFamily .Network.IP}}`:                             "4",
+		`{{isIPv6 "fd00::60"}}`:                                "true",
+		`{{contains "web" .Network.Vars.roles}}`:               "true",
+		`{{contains "ssh" .Network.Vars.roles}}`:               "false",
+		`{{contains "la" .NetworkName}}`:                       "true",
+		`{{contains "roles" .Network.Vars}}`:                   "true",
+		`{{required "ip is required" .Network.IP}}`:            "192.168.1.13",
+		`{{portRange ":" "8000-8100"}}`:                        "8000:8100",
+		`{{portRange "-" 22}}`:                                 "22",
+	} {
+		r, err := parse(rule)
+		unittest.IsNil(t, err)
+		unittest.Equals(t, r, expected)
+	}
+	_, err := parse(`{{required "interface is required" (index .Network.Vars "interface")}}`)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, strings.HasSuffix(err.Error(), "interface is required"), true)
+	_, err = parse(`{{cidr "nope" 24}}`)
+	unittest.NotNil(t, err)
+	_, err = parse(`{{portRange ":" "100-10"}}`)
+	unittest.NotNil(t, err)
+	// functions are available to structured rules
+	s, err := (&Firewall_Rule{Source: "{{cidr .Network.IP 24}}"}).Resolve(vars)
+	unittest.IsNil(t, err)
+	unittest.Equals(t, s.Source, "192.168.1.0/24")
+
+	// register our own functions
+	rule := &Firewall_Rule{Rule: `{{lower "ACCEPT"}}`}
+	unittest.NotNil(t, rule.Check())
+	unittest.IsNil(t, RegisterTemplateFunc("lower", strings.ToLower))
+	t.Cleanup(func() {
+		// unregister "lower" so our test can run again, cached templates are compiled again
+		template_funcs_mutex.Lock()
+		defer template_funcs_mutex.Unlock()
+		delete(template_funcs, "lower")
+		template_funcs_generation++
+	})
+	unittest.IsNil(t, rule.Check())
+	r, err := parse(`{{lower "ACCEPT"}}`)
+	unittest.IsNil(t, err)
+	unittest.Equals(t, r, "accept")
+	unittest.NotNil(t, RegisterTemplateFunc("lower", strings.ToLower))
+	unittest.NotNil(t, RegisterTemplateFunc("upper", strings.ToLower))
+	unittest.NotNil(t, RegisterTemplateFunc("", strings.ToLower))
+	unittest.NotNil(t, RegisterTemplateFunc("invalid", "not a function"))
+}
+func TestFirewallRuleLookup(t *testing.T) {
+	fmt.Println("TestFirewallRuleLookup")
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		FirewallRulesBefore: []*Firewall_Rule{
+			&Firewall_Rule{
+				Rule: "-A INPUT -p tcp --src {{serverIP \"Monitor\" \"lan\"}} --dport {{serverVar \"Monitor\" \"agent-port\"}} -j ACCEPT",
+			},
+			&Firewall_Rule{
+				Protocol: "tcp",
+				Ports:    "{{servicePort \"MediaServer\" \"lan\" \"mysql\"}}",
+				Source:   "{{serverIP \"Monitor\" \"lan\"}}",
+			},
+		},
+		Servers: map[string]*Server{
+			"Monitor": &Server{
+				Hostname: "monitor",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "192.168.1.50",
+					},
+				},
+				Vars: map[string]interface{}{
+					"agent-port": 10050,
+				},
+			},
+			"MediaServer": &Server{
+				Hostname: "mediaserver",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "192.168.1.100",
+						ServicesAcquirable: map[string]*Service{
+							"mysql": &Service{
+								Port: 3306,
+								FirewallRules: []*Firewall_Rule{
+									&Firewall_Rule{
+										Rule: "# mysql",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	artifacts, err := fw.Render("MediaServer")
+	unittest.IsNil(t, err)
+	iptables := string(artifacts[ARTIFACT_FIREWALL]["MediaServer.iptables"])
+	unittest.Equals(t, strings.Contains(iptables, "-A INPUT -p tcp --src 192.168.1.50 --dport 10050 -j ACCEPT\n"), true)
+	unittest.Equals(t, strings.Contains(iptables, "-A INPUT -p tcp --src 192.168.1.50 --dport 3306 -j ACCEPT\n"), true)
+
+	// unknown names fail our build
+	for rule, expected := range map[string]string{
+		`{{serverIP "Missing" "lan"}}`:               `serverIP: server: "Missing" not found`,
+		`{{serverIP "Monitor" "wan"}}`:               `serverIP: server: "Monitor" network: "wan" not found`,
+		`{{servicePort "MediaServer" "lan" "http"}}`: `servicePort: server: "MediaServer" network: "lan" service: "http" not found`,
+		`{{serverVar "Monitor" "missing"}}`:          `serverVar: server: "Monitor" has no entry for key "missing"`,
+		`{{servicePort "Missing" "lan" "mysql"}}`:    `servicePort: server: "Missing" not found`,
+	} {
+		fw.FirewallRulesBefore[0].Rule = rule
+		_, err = fw.Render("MediaServer")
+		unittest.NotNil(t, err)
+		unittest.Equals(t, strings.HasPrefix(err.Error(), "firewall-rules-before[0]: "), true)
+		unittest.Equals(t, strings.HasSuffix(err.Error(), expected), true)
+	}
+	unittest.Equals(t, fw.Build(&Settings{BuildPath: "unittest"}), false)
+}
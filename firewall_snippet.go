@@ -0,0 +1,44 @@
+package firewall
+
+// snippet returns a rule of Firewall.Snippets
+func (self *Firewall) snippet(
+	name string,
+) (*Firewall_Rule, error) {
+	if self == nil {
+		return nil, newError(joinPath("snippets", name), "no Firewall to look up snippet")
+	}
+	snippet, ok := self.Snippets[name]
+	if !ok || snippet == nil {
+		return nil, newError(joinPath("snippets", name), "snippet not found")
+	}
+	return snippet, nil
+}
+
+// validateSnippets checks our snippets and that every snippet a rule refers to exists
+func (self *Firewall) validateSnippets() Firewall_Errors {
+	var errs Firewall_Errors
+	for name, snippet := range self.Snippets {
+		path := joinPath("snippets", name)
+		if name == "" {
+			errs = append(errs, newError("snippets", "name empty"))
+		}
+		errs = append(errs, snippet.validate(path)...)
+		// snippets are only expanded once
+		if snippet != nil &&
+			snippet.Snippet != "" {
+			errs = append(errs, newError(joinPath(path, "snippet"), "a snippet can't refer to another snippet"))
+		}
+	}
+	self.walkRules(func(
+		path string,
+		rule *Firewall_Rule,
+	) {
+		if rule.Snippet == "" {
+			return
+		}
+		if _, ok := self.Snippets[rule.Snippet]; !ok {
+			errs = append(errs, newError(joinPath(path, "snippet"), "\"%s\" not found", rule.Snippet))
+		}
+	})
+	return errs
+}
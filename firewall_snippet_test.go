@@ -0,0 +1,128 @@
+package firewall
+
+import (
+	"fmt"
+	"github.com/sabey/unittest"
+	"strings"
+	"testing"
+)
+
+func TestFirewallSnippet(t *testing.T) {
+	fmt.Println("TestFirewallSnippet")
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Snippets: map[string]*Firewall_Rule{
+			"accept-port": &Firewall_Rule{
+				Rule: "-A INPUT -p {{param \"protocol\"}} --dport {{.Service.Port}} -j ACCEPT",
+			},
+			"accept-from": &Firewall_Rule{
+				Protocol: "tcp",
+				Ports:    "{{.DestinationService.Port}}",
+				Source:   "{{.SourceNetwork.IP}}",
+			},
+		},
+		Servers: map[string]*Server{
+			"Web": &Server{
+				Hostname: "web",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.5.1",
+						ServicesPassive: map[string]*Service{
+							"http": &Service{
+								Port: 80,
+								FirewallRules: []*Firewall_Rule{
+									&Firewall_Rule{
+										Snippet: "accept-port",
+										Params: map[string]interface{}{
+											"protocol": "tcp",
+										},
+									},
+								},
+							},
+							"dns": &Service{
+								Port: 53,
+								FirewallRules: []*Firewall_Rule{
+									&Firewall_Rule{
+										Snippet: "accept-port",
+										Params: map[string]interface{}{
+											"protocol": "udp",
+										},
+									},
+								},
+							},
+						},
+						ServicesAcquirable: map[string]*Service{
+							"mysql": &Service{
+								Port: 3306,
+								FirewallRules: []*Firewall_Rule{
+									&Firewall_Rule{
+										Snippet: "accept-from",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"Client": &Server{
+				Hostname: "client",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.5.2",
+						ServiceDependencies: map[string]map[string]map[string]*Service{
+							"Web": map[string]map[string]*Service{
+								"lan": map[string]*Service{
+									"mysql": nil,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	unittest.IsNil(t, fw.Validate())
+	// snippets expand with the context of the rule that refers to them
+	artifacts, err := fw.Render("Web")
+	unittest.IsNil(t, err)
+	iptables := string(artifacts[ARTIFACT_FIREWALL]["Web.iptables"])
+	unittest.Equals(t, strings.Contains(iptables, "### Service: http\n-A INPUT -p tcp --dport 80 -j ACCEPT\n"), true)
+	unittest.Equals(t, strings.Contains(iptables, "### Service: dns\n-A INPUT -p udp --dport 53 -j ACCEPT\n"), true)
+	unittest.Equals(t, strings.Contains(iptables, "-A INPUT -p tcp --src 10.0.5.2 --dport 3306 -j ACCEPT\n"), true)
+
+	// a missing param fails
+	fw.Servers["Web"].Networks["lan"].ServicesPassive["dns"].FirewallRules[0].Params = map[string]interface{}{}
+	_, err = fw.Render("Web")
+	unittest.NotNil(t, err)
+	unittest.Equals(t, strings.HasPrefix(err.Error(), "servers.Web.networks.lan.services-passive.dns.rules[0]: "), true)
+	unittest.Equals(t, strings.HasSuffix(err.Error(), `param: "protocol" not set`), true)
+	fw.Servers["Web"].Networks["lan"].ServicesPassive["dns"].FirewallRules[0].Params = nil
+
+	// unknown snippets, snippets referring to snippets and snippets combined with rules are invalid
+	fw.Snippets["nested"] = &Firewall_Rule{
+		Snippet: "accept-port",
+	}
+	fw.Servers["Client"].Networks["lan"].FirewallRulesBefore = []*Firewall_Rule{
+		&Firewall_Rule{
+			Snippet: "missing",
+		},
+		&Firewall_Rule{
+			Rule:    "-F",
+			Snippet: "accept-port",
+		},
+		&Firewall_Rule{
+			Rule: "-F",
+			Params: map[string]interface{}{
+				"protocol": "tcp",
+			},
+		},
+	}
+	errs := fw.Validate()
+	unittest.Equals(t, len(errs), 4)
+	unittest.Equals(t, errs.Error(), strings.Join([]string{
+		`servers.Client.networks.lan.firewall-rules-before[0].snippet: "missing" not found`,
+		`servers.Client.networks.lan.firewall-rules-before[1].snippet: can't be combined with a raw or structured rule`,
+		`servers.Client.networks.lan.firewall-rules-before[2].params: requires a snippet`,
+		`snippets.nested.snippet: a snippet can't refer to another snippet`,
+	}, "\n"))
+}
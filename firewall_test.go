@@ -451,6 +451,196 @@ stuff`,
 	unittest.IsNil(t, err)
 	unittest.Equals(t, bytes.Equal(first, second), true)
 }
+func TestFirewallNFTables(t *testing.T) {
+	fmt.Println("TestFirewallNFTables")
+	settings := &Settings{
+		BuildPath: "unittest",
+	}
+	fw := &Firewall{
+		FirewallType: FIREWALL_NFTABLES,
+		FirewallRulesBefore: []*Firewall_Rule{
+			&Firewall_Rule{
+				Rule: "flush ruleset",
+			},
+			&Firewall_Rule{
+				Rule: "add table inet filter",
+			},
+			&Firewall_Rule{
+				Rule: "add chain inet filter input { type filter hook input priority 0; policy drop; }",
+			},
+			&Firewall_Rule{
+				Rule: "add rule inet filter input ct state established,related accept",
+			},
+		},
+		FirewallRulesAfter: []*Firewall_Rule{
+			&Firewall_Rule{
+				Rule: "add rule inet filter input log prefix \"{{.Firewall.Vars.prefix}}\" drop",
+			},
+		},
+		Vars: map[string]interface{}{
+			"prefix": "nft dropped: ",
+		},
+	}
+	database := &Server{
+		Hostname: "database",
+		Networks: map[string]*Network{
+			"lan": &Network{
+				IP: "192.168.1.40",
+				ServicesPassive: map[string]*Service{
+					"http": &Service{
+						Port: 80,
+						FirewallRules: []*Firewall_Rule{
+							&Firewall_Rule{
+								Rule: "add rule inet filter input tcp dport {{.Service.Port}} accept",
+							},
+						},
+					},
+				},
+				ServicesAcquirable: map[string]*Service{
+					"postgresql": &Service{
+						Port: 5432,
+						FirewallRules: []*Firewall_Rule{
+							// structured rules are written in the syntax of our firewall type
+							&Firewall_Rule{
+								Protocol: "tcp",
+								Ports:    "{{.DestinationService.Port}}",
+								Source:   "{{.SourceNetwork.IP}}",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	web := &Server{
+		Hostname: "web",
+		Networks: map[string]*Network{
+			"lan": &Network{
+				IP: "192.168.1.41",
+				ServiceDependencies: map[string]map[string]map[string]*Service{
+					"Database": map[string]map[string]*Service{
+						"lan": map[string]*Service{
+							"postgresql": nil,
+						},
+					},
+				},
+			},
+		},
+	}
+	fw.Servers = map[string]*Server{
+		"Database": database,
+		"Web":      web,
+	}
+	unittest.Equals(t, fw.Build(settings), true)
+	for _, name := range []string{"Database", "Web"} {
+		first, err := ioutil.ReadFile(fmt.Sprintf("%s/%s.nft", fw.pathFirewall(settings), name))
+		unittest.IsNil(t, err)
+		unittest.Equals(t, len(first) > 0, true)
+		second, err := ioutil.ReadFile(fmt.Sprintf("%s/../../results/firewall/%s.nft", fw.pathFirewall(settings), name))
+		unittest.IsNil(t, err)
+		unittest.Equals(t, bytes.Equal(first, second), true)
+	}
+}
+func TestFirewallIPv6(t *testing.T) {
+	fmt.Println("TestFirewallIPv6")
+	settings := &Settings{
+		BuildPath: "unittest",
+	}
+	ssh := &Service{
+		Port: 22,
+		FirewallRules: []*Firewall_Rule{
+			&Firewall_Rule{
+				Rule: "-A INPUT -p tcp --dport {{.Service.Port}} -j ACCEPT # IPv{{.Family}}",
+			},
+		},
+	}
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		FirewallRulesBefore: []*Firewall_Rule{
+			&Firewall_Rule{
+				Rule: "-A INPUT -p {{if eq .Family 6}}ipv6-icmp{{else}}icmp{{end}} -j ACCEPT",
+			},
+		},
+	}
+	dual := &Server{
+		Hostname: "dual",
+		Networks: map[string]*Network{
+			"lan": &Network{
+				IP: "192.168.1.60",
+				ServicesPassive: map[string]*Service{
+					"ssh": ssh,
+				},
+			},
+			"lan6": &Network{
+				IP: "fd00::60",
+				ServicesPassive: map[string]*Service{
+					"ssh": ssh,
+				},
+				ServicesAcquirable: map[string]*Service{
+					"mysql": &Service{
+						Port: 3306,
+						FirewallRules: []*Firewall_Rule{
+							&Firewall_Rule{
+								Rule: "-A INPUT -p tcp --src {{.SourceNetwork.IP}} --dport {{.DestinationService.Port}} -j ACCEPT # IPv{{.Family}}",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	client := &Server{
+		Hostname: "client",
+		Networks: map[string]*Network{
+			"lan6": &Network{
+				IP: "fd00::61",
+				ServiceDependencies: map[string]map[string]map[string]*Service{
+					"Dual": map[string]map[string]*Service{
+						"lan6": map[string]*Service{
+							"mysql": nil,
+						},
+					},
+				},
+			},
+		},
+	}
+	fw.Servers = map[string]*Server{
+		"Dual":    dual,
+		"Client6": client,
+	}
+	unittest.Equals(t, fw.Build(settings), true)
+	for _, file := range []string{
+		"Dual.iptables",
+		"Dual.ip6tables",
+		"Client6.iptables",
+		"Client6.ip6tables",
+	} {
+		first, err := ioutil.ReadFile(fmt.Sprintf("%s/%s", fw.pathFirewall(settings), file))
+		unittest.IsNil(t, err)
+		unittest.Equals(t, len(first) > 0, true)
+		second, err := ioutil.ReadFile(fmt.Sprintf("%s/../../results/firewall/%s", fw.pathFirewall(settings), file))
+		unittest.IsNil(t, err)
+		unittest.Equals(t, bytes.Equal(first, second), true)
+	}
+	// IPv4 networks can't acquire IPv6 services
+	fw.Servers["Client4"] = &Server{
+		Hostname: "client4",
+		Networks: map[string]*Network{
+			"lan": &Network{
+				IP: "192.168.1.61",
+				ServiceDependencies: map[string]map[string]map[string]*Service{
+					"Dual": map[string]map[string]*Service{
+						"lan6": map[string]*Service{
+							"mysql": nil,
+						},
+					},
+				},
+			},
+		},
+	}
+	unittest.Equals(t, fw.BuildServer(settings, "Client4"), false)
+	unittest.Equals(t, fw.BuildServer(settings, "Dual"), false)
+}
 func junk(
 	i int,
 ) string {
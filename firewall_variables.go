@@ -8,6 +8,11 @@ type Firewall_Variables_Server struct {
 	ServerName string    `json:"server-name,omitempty"`
 	Server     *Server   `json:"server,omitempty"`
 	Firewall   *Firewall `json:"firewall,omitempty"`
+	// IP Family the rule is being rendered for
+	// 4 or 6, or 0 if the rule is rendered for both
+	Family int `json:"family,omitempty"`
+	// Firewall and Server Vars merged, Server Vars override Firewall Vars
+	Vars map[string]interface{} `json:"vars,omitempty"`
 }
 
 func (self *Firewall_Variables_Server) IsValid() bool {
@@ -24,6 +29,11 @@ type Firewall_Variables_Network struct {
 	NetworkName string    `json:"network-name,omitempty"`
 	Network     *Network  `json:"network,omitempty"`
 	Firewall    *Firewall `json:"firewall,omitempty"`
+	// IP Family the rule is being rendered for
+	// 4 or 6, or 0 if the rule is rendered for both
+	Family int `json:"family,omitempty"`
+	// Firewall, Server and Network Vars merged, the most specific level wins
+	Vars map[string]interface{} `json:"vars,omitempty"`
 }
 
 func (self *Firewall_Variables_Network) IsValid() bool {
@@ -42,6 +52,11 @@ type Firewall_Variables_Service_Passive struct {
 	ServiceName string    `json:"service-name,omitempty"`
 	Service     *Service  `json:"service,omitempty"`
 	Firewall    *Firewall `json:"firewall,omitempty"`
+	// IP Family the rule is being rendered for
+	// 4 or 6, or 0 if the rule is rendered for both
+	Family int `json:"family,omitempty"`
+	// Firewall, Server, Network and Service Vars merged, the most specific level wins
+	Vars map[string]interface{} `json:"vars,omitempty"`
 }
 
 func (self *Firewall_Variables_Service_Passive) IsValid() bool {
@@ -65,6 +80,12 @@ type Firewall_Variables_Service_Acquirable struct {
 	DestinationNetwork     *Network  `json:"destination-network,omitempty"`
 	DestinationService     *Service  `json:"destination-service,omitempty"`
 	Firewall               *Firewall `json:"firewall,omitempty"`
+	// IP Family the rule is being rendered for
+	// 4 or 6, or 0 if the rule is rendered for both
+	Family int `json:"family,omitempty"`
+	// Firewall, Destination Server, Network and Service Vars merged, the most specific level wins
+	// our destination is the server being built
+	Vars map[string]interface{} `json:"vars,omitempty"`
 }
 
 func (self *Firewall_Variables_Service_Acquirable) IsValid() bool {
@@ -88,6 +109,12 @@ type Firewall_Variables_Service_Dependencies struct {
 	DestinationNetwork     *Network  `json:"destination-network,omitempty"`
 	DestinationService     *Service  `json:"destination-service,omitempty"`
 	Firewall               *Firewall `json:"firewall,omitempty"`
+	// IP Family the rule is being rendered for
+	// 4 or 6, or 0 if the rule is rendered for both
+	Family int `json:"family,omitempty"`
+	// Firewall, Destination Server, Network and Service Vars merged, the most specific level wins
+	// our destination is the server being built
+	Vars map[string]interface{} `json:"vars,omitempty"`
 }
 
 func (self *Firewall_Variables_Service_Dependencies) IsValid() bool {
@@ -97,3 +124,20 @@ func (self *Firewall_Variables_Service_Dependencies) IsValid() bool {
 	}
 	return true
 }
+
+// mergeVars merges levels of Vars in order, a later level overrides an earlier one
+// nil is returned if every level is empty
+func mergeVars(
+	levels ...map[string]interface{},
+) map[string]interface{} {
+	var vars map[string]interface{}
+	for _, level := range levels {
+		for key, value := range level {
+			if vars == nil {
+				vars = make(map[string]interface{})
+			}
+			vars[key] = value
+		}
+	}
+	return vars
+}
@@ -2,4 +2,8 @@ module github.com/sabey/firewall
 
 go 1.16
 
-require github.com/sabey/unittest v0.0.0-20210829005658-0dfd5e1f42f3
+require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/sabey/unittest v0.0.0-20210829005658-0dfd5e1f42f3
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,255 @@
+package firewall
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	FORMAT_JSON = "json"
+	FORMAT_YAML = "yaml"
+	FORMAT_TOML = "toml"
+)
+
+// FormatByPath returns the format of a config file by its extension
+// ".json", ".yaml", ".yml" or ".toml"
+func FormatByPath(
+	path string,
+) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FORMAT_JSON, nil
+	case ".yaml", ".yml":
+		return FORMAT_YAML, nil
+	case ".toml":
+		return FORMAT_TOML, nil
+	}
+	return "", fmt.Errorf("unknown config format: \"%s\"", path)
+}
+
+// Unmarshal decodes a Firewall or Settings config of format into v
+// YAML and TOML use the same attribute names as our json tags
+// they're decoded to JSON first, so the result is identical to loading the equivalent JSON
+// unknown attributes and values of the wrong type are returned as Firewall_Errors
+// only JSON errors have a line and column, since YAML and TOML are checked after they're converted
+func Unmarshal(
+	format string,
+	data []byte,
+	v interface{},
+) error {
+	switch format {
+	case FORMAT_JSON:
+		return decodeJSON(data, v, true)
+	case FORMAT_YAML:
+		var decoded interface{}
+		if err := yaml.Unmarshal(data, &decoded); err != nil {
+			return err
+		}
+		return remarshal(normalizeYAML(decoded), v)
+	case FORMAT_TOML:
+		decoded := make(map[string]interface{})
+		if _, err := toml.Decode(string(data), &decoded); err != nil {
+			return err
+		}
+		return remarshal(decoded, v)
+	}
+	return fmt.Errorf("unknown config format: \"%s\"", format)
+}
+
+// Marshal encodes a Firewall or Settings config in format
+// YAML and TOML are encoded from JSON, so they use the same attribute names as our json tags
+// TOML has no null, so a null value is returned as a Firewall_Error instead of being written as something else
+// ie: a nil service dependency, which can be written as an empty Service
+func Marshal(
+	format string,
+	v interface{},
+) ([]byte, error) {
+	if format == FORMAT_JSON {
+		bs, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(bs, '\n'), nil
+	}
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(bs, &decoded); err != nil {
+		return nil, err
+	}
+	switch format {
+	case FORMAT_YAML:
+		return yaml.Marshal(normalizeJSON(decoded))
+	case FORMAT_TOML:
+		if path, ok := findNull(decoded, ""); ok {
+			return nil, newError(path, "null can't be written as TOML")
+		}
+		buff := &bytes.Buffer{}
+		if err := toml.NewEncoder(buff).Encode(normalizeJSON(decoded)); err != nil {
+			return nil, err
+		}
+		return buff.Bytes(), nil
+	}
+	return nil, fmt.Errorf("unknown config format: \"%s\"", format)
+}
+
+// LoadFirewall reads a Firewall from a JSON, YAML or TOML file, see `FormatByPath`
+func LoadFirewall(
+	path string,
+) (*Firewall, error) {
+	fw := &Firewall{}
+	if err := load(path, fw); err != nil {
+		return nil, err
+	}
+	return fw, nil
+}
+
+// LoadSettings reads Settings from a JSON, YAML or TOML file, see `FormatByPath`
+func LoadSettings(
+	path string,
+) (*Settings, error) {
+	settings := &Settings{}
+	if err := load(path, settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+func load(
+	path string,
+	v interface{},
+) error {
+	format, err := FormatByPath(path)
+	if err != nil {
+		return err
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := Unmarshal(format, bs, v); err != nil {
+		return fileError(path, err)
+	}
+	return nil
+}
+
+// fileError sets the File of our Firewall_Errors, any other error is prefixed with file
+func fileError(
+	file string,
+	err error,
+) error {
+	var ferr *Firewall_Error
+	if errs, ok := err.(Firewall_Errors); ok {
+		for _, ferr := range errs {
+			ferr.File = file
+		}
+		return errs
+	} else if errors.As(err, &ferr) {
+		ferr.File = file
+		return ferr
+	}
+	return fmt.Errorf("%s: %w", file, err)
+}
+
+// remarshal decodes a generic value into v through JSON
+func remarshal(
+	decoded interface{},
+	v interface{},
+) error {
+	bs, err := json.Marshal(decoded)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(bs, v, false)
+}
+
+// normalizeYAML converts maps with non string keys, which JSON can't encode
+// ie: a port used as a key
+func normalizeYAML(
+	decoded interface{},
+) interface{} {
+	switch decoded := decoded.(type) {
+	case map[string]interface{}:
+		for key, value := range decoded {
+			decoded[key] = normalizeYAML(value)
+		}
+		return decoded
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(decoded))
+		for key, value := range decoded {
+			m[fmt.Sprint(key)] = normalizeYAML(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range decoded {
+			decoded[i] = normalizeYAML(value)
+		}
+		return decoded
+	}
+	return decoded
+}
+
+// normalizeJSON converts whole numbers decoded from JSON back to integers
+// so a port isn't written as 22.0
+func normalizeJSON(
+	decoded interface{},
+) interface{} {
+	switch decoded := decoded.(type) {
+	case map[string]interface{}:
+		for key, value := range decoded {
+			decoded[key] = normalizeJSON(value)
+		}
+		return decoded
+	case []interface{}:
+		for i, value := range decoded {
+			decoded[i] = normalizeJSON(value)
+		}
+		return decoded
+	case float64:
+		if decoded == math.Trunc(decoded) &&
+			math.Abs(decoded) < 1<<53 {
+			return int64(decoded)
+		}
+	}
+	return decoded
+}
+
+// findNull returns the path of the first null value decoded from JSON
+// keys are sorted so our path is deterministic
+func findNull(
+	decoded interface{},
+	path string,
+) (string, bool) {
+	switch decoded := decoded.(type) {
+	case nil:
+		return path, true
+	case map[string]interface{}:
+		keys := []string{}
+		for key, _ := range decoded {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if path, ok := findNull(decoded[key], joinPath(path, key)); ok {
+				return path, true
+			}
+		}
+	case []interface{}:
+		for i, value := range decoded {
+			if path, ok := findNull(value, indexPath(path, i)); ok {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
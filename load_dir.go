@@ -0,0 +1,207 @@
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// the root file of a fleet directory, ie: "firewall.yaml"
+	// contains our FirewallType, global rules, vars and snippets
+	dir_root = "firewall"
+	// one file per server, named after the server, ie: "servers/MyPC.yaml"
+	dir_servers = "servers"
+)
+
+// every extension supported by `FormatByPath`
+var dir_extensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+// LoadFirewallDir assembles a Firewall from a fleet directory
+// the root file "firewall.<ext>" contains our globals and "servers/<ServerName>.<ext>" contains a single Server
+// files can be JSON, YAML or TOML, see `FormatByPath`, other files in "servers/" are ignored
+// a server defined more than once, within the root file or by two server files, is reported as a Firewall_Error
+func LoadFirewallDir(
+	dir string,
+) (*Firewall, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, dir_root+".*"))
+	if err != nil {
+		return nil, err
+	}
+	roots := []string{}
+	for _, match := range matches {
+		// only our root with a supported extension, ignore backups such as "firewall.json~" or "firewall.json.swp"
+		if _, err := FormatByPath(match); err != nil ||
+			strings.TrimSuffix(filepath.Base(match), filepath.Ext(match)) != dir_root {
+			continue
+		}
+		roots = append(roots, match)
+	}
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("%s: root file \"%s.<json|yaml|yml|toml>\" not found", dir, dir_root)
+	}
+	if len(roots) > 1 {
+		return nil, fmt.Errorf("%s: only one root file is allowed: %s", dir, strings.Join(roots, ", "))
+	}
+	fw, err := LoadFirewall(roots[0])
+	if err != nil {
+		return nil, err
+	}
+	// [ServerName]File
+	files := make(map[string]string)
+	for name, _ := range fw.Servers {
+		files[name] = roots[0]
+	}
+	infos, err := ioutil.ReadDir(filepath.Join(dir, dir_servers))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var errs Firewall_Errors
+	// ReadDir is sorted so any failure is deterministic
+	for _, info := range infos {
+		if info.IsDir() ||
+			strings.HasPrefix(info.Name(), ".") {
+			// ignore folders and hidden files
+			continue
+		}
+		if _, err := FormatByPath(info.Name()); err != nil {
+			// ignore anything that isn't a config, ie: "README.md" or an editor swap file
+			continue
+		}
+		file := filepath.Join(dir, dir_servers, info.Name())
+		name := strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+		if first, ok := files[name]; ok {
+			errs = append(errs, newError(serverPath(name), "duplicate server: defined in \"%s\" and \"%s\"", first, file))
+			continue
+		}
+		files[name] = file
+		server := &Server{}
+		if err := load(file, server); err != nil {
+			// our paths are relative to the server file
+			return nil, prefixError(serverPath(name), err)
+		}
+		if fw.Servers == nil {
+			fw.Servers = make(map[string]*Server)
+		}
+		fw.Servers[name] = server
+	}
+	if len(errs) > 0 {
+		sort.Sort(errs)
+		return nil, errs
+	}
+	return fw, nil
+}
+
+// prefixError prefixes the path of our Firewall_Errors with path
+func prefixError(
+	path string,
+	err error,
+) error {
+	prefix := func(ferr *Firewall_Error) {
+		if ferr.Path == "" {
+			ferr.Path = path
+		} else {
+			ferr.Path = joinPath(path, ferr.Path)
+		}
+	}
+	var ferr *Firewall_Error
+	if errs, ok := err.(Firewall_Errors); ok {
+		for _, ferr := range errs {
+			prefix(ferr)
+		}
+	} else if errors.As(err, &ferr) {
+		prefix(ferr)
+	}
+	return err
+}
+
+// WriteDir writes our Firewall to a fleet directory in format, see `LoadFirewallDir`
+// every server is written to its own file and the root file contains everything else
+// existing files of our servers are replaced, server files of other formats are removed so they aren't loaded twice
+// server files of servers that aren't ours are removed, so loading our directory returns our Firewall
+// a server name must be a valid filename that isn't hidden, since hidden files aren't loaded
+func (self *Firewall) WriteDir(
+	dir string,
+	format string,
+) error {
+	switch format {
+	case FORMAT_JSON, FORMAT_YAML, FORMAT_TOML:
+	default:
+		return fmt.Errorf("unknown config format: \"%s\"", format)
+	}
+	// sort servers so any failure is deterministic
+	sorted := []string{}
+	for name, _ := range self.Servers {
+		if name == "" ||
+			strings.HasPrefix(name, ".") ||
+			strings.ContainsAny(name, `/\`) {
+			return newError(serverPath(name), "server name can't be used as a filename")
+		}
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	if err := os.MkdirAll(filepath.Join(dir, dir_servers), 0755); err != nil {
+		return err
+	}
+	// our root without servers
+	root := *self
+	root.Servers = nil
+	if err := writeDirFile(dir, dir_root, format, &root); err != nil {
+		return err
+	}
+	for _, name := range sorted {
+		if err := writeDirFile(filepath.Join(dir, dir_servers), name, format, self.Servers[name]); err != nil {
+			return err
+		}
+	}
+	// remove the files of servers we no longer have
+	infos, err := ioutil.ReadDir(filepath.Join(dir, dir_servers))
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if info.IsDir() ||
+			strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if _, err := FormatByPath(info.Name()); err != nil {
+			continue
+		}
+		if _, ok := self.Servers[strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, dir_servers, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDirFile writes v to "<dir>/<name>.<format>" and removes "<dir>/<name>" of any other format
+func writeDirFile(
+	dir string,
+	name string,
+	format string,
+	v interface{},
+) error {
+	bs, err := Marshal(format, v)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, fmt.Sprintf("%s.%s", name, format))
+	// only our own name with a supported extension, "web.prod.yaml" belongs to server "web.prod" and not "web"
+	for _, ext := range dir_extensions {
+		other := filepath.Join(dir, name+ext)
+		if other == file {
+			continue
+		}
+		if err := os.Remove(other); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return ioutil.WriteFile(file, bs, 0644)
+}
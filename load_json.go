@@ -0,0 +1,286 @@
+package firewall
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// json_decoder walks a JSON document along the type it's decoded into
+// unknown fields and values of the wrong type are reported with their path and position
+type json_decoder struct {
+	data    []byte
+	decoder *json.Decoder
+	// positions are only meaningful if our data was written by the user
+	positions bool
+	errs      Firewall_Errors
+}
+
+// decodeJSON strictly decodes data into v
+// every unknown field and value of the wrong type is returned as Firewall_Errors
+// if positions is true our errors include the line and column of the value
+func decodeJSON(
+	data []byte,
+	v interface{},
+	positions bool,
+) error {
+	d := &json_decoder{
+		data:      data,
+		decoder:   json.NewDecoder(bytes.NewReader(data)),
+		positions: positions,
+	}
+	d.decoder.UseNumber()
+	if err := d.value("", reflect.TypeOf(v)); err != nil {
+		return err
+	}
+	if _, err := d.decoder.Token(); err != io.EOF {
+		return d.newError("", d.start(), "unexpected data after the top level value")
+	}
+	if len(d.errs) > 0 {
+		sort.Sort(d.errs)
+		return d.errs
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		var terr *json.UnmarshalTypeError
+		if errors.As(err, &terr) {
+			return d.newError(terr.Field, int(terr.Offset), "cannot decode %s into %s", terr.Value, terr.Type)
+		}
+		return err
+	}
+	return nil
+}
+
+// value reads the next value, which is decoded into t
+// only a syntax error stops our walk
+func (self *json_decoder) value(
+	path string,
+	t reflect.Type,
+) error {
+	start := self.start()
+	token, err := self.decoder.Token()
+	if err != nil {
+		return self.syntax(path, err)
+	}
+	if token == nil {
+		// null is valid for every type
+		return nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if token != json.Delim('{') {
+			return self.invalid(path, start, token, "an object")
+		}
+		fields := jsonFields(t)
+		for self.decoder.More() {
+			start := self.start()
+			key, err := self.key()
+			if err != nil {
+				return self.syntax(path, err)
+			}
+			field, ok := fields[key]
+			if !ok {
+				self.errs = append(self.errs, self.newError(path, start, "unknown field \"%s\"", key))
+				if err := self.skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := self.value(joinPath(path, key), field); err != nil {
+				return err
+			}
+		}
+		return self.end(path)
+	case reflect.Map:
+		if token != json.Delim('{') {
+			return self.invalid(path, start, token, "an object")
+		}
+		for self.decoder.More() {
+			key, err := self.key()
+			if err != nil {
+				return self.syntax(path, err)
+			}
+			if err := self.value(joinPath(path, key), t.Elem()); err != nil {
+				return err
+			}
+		}
+		return self.end(path)
+	case reflect.Slice, reflect.Array:
+		if token != json.Delim('[') {
+			return self.invalid(path, start, token, "an array")
+		}
+		for i := 0; self.decoder.More(); i++ {
+			if err := self.value(indexPath(path, i), t.Elem()); err != nil {
+				return err
+			}
+		}
+		return self.end(path)
+	case reflect.String:
+		if _, ok := token.(string); !ok {
+			return self.invalid(path, start, token, "a string")
+		}
+	case reflect.Bool:
+		if _, ok := token.(bool); !ok {
+			return self.invalid(path, start, token, "a boolean")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		if _, ok := token.(json.Number); !ok {
+			return self.invalid(path, start, token, "a number")
+		}
+	default:
+		// interface{} accepts anything
+		return self.rest(token)
+	}
+	return nil
+}
+
+// key reads the key of an object
+func (self *json_decoder) key() (string, error) {
+	token, err := self.decoder.Token()
+	if err != nil {
+		return "", err
+	}
+	// the decoder only returns strings as keys
+	return token.(string), nil
+}
+
+// end reads the closing delimiter of an object or array
+func (self *json_decoder) end(
+	path string,
+) error {
+	if _, err := self.decoder.Token(); err != nil {
+		return self.syntax(path, err)
+	}
+	return nil
+}
+
+// skip reads the next value without checking it
+func (self *json_decoder) skip() error {
+	token, err := self.decoder.Token()
+	if err != nil {
+		return self.syntax("", err)
+	}
+	return self.rest(token)
+}
+
+// rest reads the remainder of a value that started with token
+func (self *json_decoder) rest(
+	token json.Token,
+) error {
+	if token != json.Delim('{') &&
+		token != json.Delim('[') {
+		return nil
+	}
+	for depth := 1; depth > 0; {
+		token, err := self.decoder.Token()
+		if err != nil {
+			return self.syntax("", err)
+		}
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// invalid records a value of the wrong type and skips it
+func (self *json_decoder) invalid(
+	path string,
+	start int,
+	token json.Token,
+	expected string,
+) error {
+	self.errs = append(self.errs, self.newError(path, start, "expected %s", expected))
+	return self.rest(token)
+}
+
+// syntax converts a syntax error of our decoder
+func (self *json_decoder) syntax(
+	path string,
+	err error,
+) error {
+	var serr *json.SyntaxError
+	if errors.As(err, &serr) {
+		// Offset is after the invalid character
+		return self.newError(path, int(serr.Offset)-1, "%s", serr)
+	}
+	if err == io.EOF ||
+		err == io.ErrUnexpectedEOF {
+		return self.newError(path, len(self.data), "unexpected end of JSON input")
+	}
+	return self.newError(path, self.start(), "%s", err)
+}
+
+// start is the offset of our next token
+func (self *json_decoder) start() int {
+	offset := int(self.decoder.InputOffset())
+	for offset < len(self.data) &&
+		strings.IndexByte(" \t\r\n:,", self.data[offset]) > -1 {
+		offset++
+	}
+	return offset
+}
+
+// newError creates an error at path, with the position of offset
+func (self *json_decoder) newError(
+	path string,
+	offset int,
+	format string,
+	a ...interface{},
+) *Firewall_Error {
+	err := newError(path, format, a...)
+	if self.positions {
+		err.Line, err.Column = position(self.data, offset)
+	}
+	return err
+}
+
+// position returns the 1 based line and column of offset
+func position(
+	data []byte,
+	offset int,
+) (int, int) {
+	if offset > len(data) {
+		offset = len(data)
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	column := offset - bytes.LastIndexByte(data[:offset], '\n')
+	return line, column
+}
+
+// jsonFields returns the fields of a struct by their json name
+func jsonFields(
+	t reflect.Type,
+) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
@@ -0,0 +1,257 @@
+package firewall
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/sabey/unittest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	fmt.Println("TestLoad")
+	// JSON is our reference
+	expected, err := LoadFirewall("unittest/config/firewall.json")
+	unittest.IsNil(t, err)
+	unittest.IsNil(t, expected.Validate())
+	expected_json, _ := json.Marshal(expected)
+	expected_artifacts, err := expected.Render("MyPC")
+	unittest.IsNil(t, err)
+	settings, err := LoadSettings("unittest/config/settings.json")
+	unittest.IsNil(t, err)
+	expected_settings, _ := json.Marshal(settings)
+	unittest.Equals(t, string(expected_settings), `{"build-path":"unittest","build-remove-folder-firewall":true,"build-concurrency":2}`)
+
+	for _, format := range []string{"yaml", "toml"} {
+		fw, err := LoadFirewall(fmt.Sprintf("unittest/config/firewall.%s", format))
+		unittest.IsNil(t, err)
+		bs, _ := json.Marshal(fw)
+		unittest.Equals(t, string(bs), string(expected_json))
+		artifacts, err := fw.Render("MyPC")
+		unittest.IsNil(t, err)
+		for kind, files := range expected_artifacts {
+			for file, contents := range files {
+				unittest.Equals(t, bytes.Equal(artifacts[kind][file], contents), true)
+			}
+		}
+		settings, err := LoadSettings(fmt.Sprintf("unittest/config/settings.%s", format))
+		unittest.IsNil(t, err)
+		bs, _ = json.Marshal(settings)
+		unittest.Equals(t, string(bs), string(expected_settings))
+	}
+
+	// the format is picked by extension
+	format, err := FormatByPath("fleet.yml")
+	unittest.IsNil(t, err)
+	unittest.Equals(t, format, FORMAT_YAML)
+	_, err = FormatByPath("fleet.ini")
+	unittest.NotNil(t, err)
+	_, err = LoadFirewall("unittest/config/missing.yaml")
+	unittest.NotNil(t, err)
+	// yaml keys that aren't strings are converted
+	vars := map[string]interface{}{}
+	unittest.IsNil(t, Unmarshal(FORMAT_YAML, []byte("80: http\n443: https\n"), &vars))
+	unittest.Equals(t, vars["443"], "https")
+	unittest.NotNil(t, Unmarshal(FORMAT_TOML, []byte("= invalid"), &vars))
+
+	// a nil service dependency is kept by JSON and YAML, TOML has no null
+	fw := &Firewall{
+		FirewallType: FIREWALL_IPTABLES,
+		Servers: map[string]*Server{
+			"MyPC": &Server{
+				Hostname: "mypc",
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "192.168.1.2",
+						ServiceDependencies: map[string]map[string]map[string]*Service{
+							"MediaServer": map[string]map[string]*Service{
+								"lan": map[string]*Service{
+									"mysql": nil,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, format := range []string{FORMAT_JSON, FORMAT_YAML} {
+		bs, err := Marshal(format, fw)
+		unittest.IsNil(t, err)
+		loaded := &Firewall{}
+		unittest.IsNil(t, Unmarshal(format, bs, loaded))
+		services := loaded.Servers["MyPC"].Networks["lan"].ServiceDependencies["MediaServer"]["lan"]
+		service, ok := services["mysql"]
+		unittest.Equals(t, ok, true)
+		unittest.IsNil(t, service)
+	}
+	_, err = Marshal(FORMAT_TOML, fw)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), "servers.MyPC.networks.lan.service-dependencies.MediaServer.lan.mysql: null can't be written as TOML")
+	// an empty service can be
+	fw.Servers["MyPC"].Networks["lan"].ServiceDependencies["MediaServer"]["lan"]["mysql"] = &Service{}
+	bs, err := Marshal(FORMAT_TOML, fw)
+	unittest.IsNil(t, err)
+	loaded := &Firewall{}
+	unittest.IsNil(t, Unmarshal(FORMAT_TOML, bs, loaded))
+	expected_json, _ = json.Marshal(fw)
+	bs, _ = json.Marshal(loaded)
+	unittest.Equals(t, string(bs), string(expected_json))
+}
+func TestLoadStrict(t *testing.T) {
+	fmt.Println("TestLoadStrict")
+	config := `{
+  "firewall-type": 1,
+  "servers": {
+    "MyPC": {
+      "hostname": "mypc",
+      "networks": {
+        "lan": {
+          "ip": "10.0.0.2",
+          "services-aquirable": {}
+        }
+      }
+    }
+  }
+}`
+	// unknown fields are reported with their path and position
+	err := Unmarshal(FORMAT_JSON, []byte(config), &Firewall{})
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), `servers.MyPC.networks.lan: unknown field "services-aquirable" at line 9:11`)
+	// every error is reported
+	err = Unmarshal(FORMAT_JSON, []byte(`{"hostnam": "mypc", "hosts": {"10.0.0.1": "gateway"}, "ssh": {"admin": {"port": "22"}}}`), &Server{})
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), strings.Join([]string{
+		`unknown field "hostnam" at line 1:2`,
+		`hosts.10.0.0.1: expected an array at line 1:43`,
+		`ssh.admin.port: expected a number at line 1:81`,
+	}, "\n"))
+	// malformed input
+	err = Unmarshal(FORMAT_JSON, []byte("{\n  \"hostname\": \"mypc\",\n}"), &Server{})
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), `invalid character ',' looking for beginning of value at line 2:21`)
+	err = Unmarshal(FORMAT_JSON, []byte(`{"networks": {"lan": {"services-passive": {"ssh": {"port": 70000}}}}}`), &Server{})
+	unittest.NotNil(t, err)
+	unittest.Equals(t, strings.HasPrefix(err.Error(), "networks.lan.services-passive.ssh.port: cannot decode number 70000 into uint16"), true)
+	// YAML and TOML are checked after they're converted to JSON, so they don't have a position
+	err = Unmarshal(FORMAT_YAML, []byte("hostname: mypc\nhostnam: mypc\n"), &Server{})
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), `unknown field "hostnam"`)
+	err = Unmarshal(FORMAT_TOML, []byte("build-pat = \"unittest\"\n"), &Settings{})
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), `unknown field "build-pat"`)
+
+	// our file is reported
+	tmp, err := ioutil.TempDir("", "firewall-load-strict-")
+	unittest.IsNil(t, err)
+	defer os.RemoveAll(tmp)
+	file := filepath.Join(tmp, "firewall.json")
+	unittest.IsNil(t, ioutil.WriteFile(file, []byte(config), 0644))
+	_, err = LoadFirewall(file)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), fmt.Sprintf(`%s: servers.MyPC.networks.lan: unknown field "services-aquirable" at line 9:11`, file))
+	errs, ok := err.(Firewall_Errors)
+	unittest.Equals(t, ok, true)
+	unittest.Equals(t, len(errs), 1)
+	ferr := errs[0]
+	unittest.Equals(t, ferr.File, file)
+	unittest.Equals(t, ferr.Line, 9)
+	unittest.Equals(t, ferr.Column, 11)
+	// server files of a fleet directory are reported by their server
+	unittest.IsNil(t, ioutil.WriteFile(file, []byte(`{"firewall-type": 1}`), 0644))
+	unittest.IsNil(t, os.Mkdir(filepath.Join(tmp, "servers"), 0755))
+	file = filepath.Join(tmp, "servers", "MyPC.json")
+	unittest.IsNil(t, ioutil.WriteFile(file, []byte(`{"hostnam": "mypc"}`), 0644))
+	_, err = LoadFirewallDir(tmp)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), fmt.Sprintf(`%s: servers.MyPC: unknown field "hostnam" at line 1:2`, file))
+}
+func TestLoadDir(t *testing.T) {
+	fmt.Println("TestLoadDir")
+	expected, err := LoadFirewall("unittest/config/firewall.json")
+	unittest.IsNil(t, err)
+	expected_json, _ := json.Marshal(expected)
+	// a root file and one file per server
+	fw, err := LoadFirewallDir("unittest/config/fleet")
+	unittest.IsNil(t, err)
+	bs, _ := json.Marshal(fw)
+	unittest.Equals(t, string(bs), string(expected_json))
+
+	tmp, err := ioutil.TempDir("", "firewall-load-dir-")
+	unittest.IsNil(t, err)
+	defer os.RemoveAll(tmp)
+	// files that aren't a config are ignored
+	unittest.IsNil(t, fw.WriteDir(tmp, FORMAT_YAML))
+	unittest.IsNil(t, ioutil.WriteFile(filepath.Join(tmp, "servers", "README.md"), []byte("# servers\n"), 0644))
+	unittest.IsNil(t, ioutil.WriteFile(filepath.Join(tmp, "servers", "MyPC.yaml.swp"), []byte{0, 1, 2}, 0644))
+	unittest.IsNil(t, ioutil.WriteFile(filepath.Join(tmp, "servers", "MyPC.yaml~"), []byte("hostnam: old\n"), 0644))
+	unittest.IsNil(t, ioutil.WriteFile(filepath.Join(tmp, "firewall.yaml~"), []byte("firewall-typ: 1\n"), 0644))
+	unittest.IsNil(t, ioutil.WriteFile(filepath.Join(tmp, "firewall.yaml.swp"), []byte{0, 1, 2}, 0644))
+	loaded, err := LoadFirewallDir(tmp)
+	unittest.IsNil(t, err)
+	bs, _ = json.Marshal(loaded)
+	unittest.Equals(t, string(bs), string(expected_json))
+	// write our fleet back out in every format
+	for _, format := range []string{FORMAT_JSON, FORMAT_YAML, FORMAT_TOML} {
+		unittest.IsNil(t, fw.WriteDir(tmp, format))
+		_, err = os.Stat(filepath.Join(tmp, "servers", fmt.Sprintf("MyPC.%s", format)))
+		unittest.IsNil(t, err)
+		loaded, err := LoadFirewallDir(tmp)
+		unittest.NotNil(t, loaded)
+		unittest.IsNil(t, err)
+		bs, _ = json.Marshal(loaded)
+		unittest.Equals(t, string(bs), string(expected_json))
+	}
+	unittest.NotNil(t, fw.WriteDir(tmp, "ini"))
+	// the files of a server whose name starts with ours aren't removed
+	fw2, err := LoadFirewallDir("unittest/config/fleet")
+	unittest.IsNil(t, err)
+	fw2.Servers["MyPC.prod"] = &Server{
+		Hostname: "prod",
+	}
+	unittest.IsNil(t, fw2.WriteDir(tmp, FORMAT_YAML))
+	unittest.IsNil(t, fw2.WriteDir(tmp, FORMAT_TOML))
+	loaded, err = LoadFirewallDir(tmp)
+	unittest.IsNil(t, err)
+	unittest.NotNil(t, loaded.Servers["MyPC"])
+	unittest.NotNil(t, loaded.Servers["MyPC.prod"])
+	// the files of servers we no longer have are removed
+	unittest.IsNil(t, fw.WriteDir(tmp, FORMAT_TOML))
+	_, err = os.Stat(filepath.Join(tmp, "servers", "MyPC.prod.toml"))
+	unittest.Equals(t, os.IsNotExist(err), true)
+	loaded, err = LoadFirewallDir(tmp)
+	unittest.IsNil(t, err)
+	bs, _ = json.Marshal(loaded)
+	unittest.Equals(t, string(bs), string(expected_json))
+	// hidden files aren't loaded, so a server can't be hidden
+	fw2.Servers[".hidden"] = &Server{
+		Hostname: "hidden",
+	}
+	delete(fw2.Servers, "MyPC.prod")
+	err = fw2.WriteDir(tmp, FORMAT_TOML)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), "servers..hidden: server name can't be used as a filename")
+
+	// duplicate servers are reported
+	unittest.IsNil(t, ioutil.WriteFile(filepath.Join(tmp, "servers", "MyPC.json"), []byte(`{"hostname":"mypc"}`), 0644))
+	unittest.IsNil(t, ioutil.WriteFile(filepath.Join(tmp, "firewall.json"), []byte(`{"firewall-type":1,"servers":{"MediaServer":{"hostname":"mediaserver"}}}`), 0644))
+	unittest.IsNil(t, os.Remove(filepath.Join(tmp, "firewall.toml")))
+	_, err = LoadFirewallDir(tmp)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), strings.Join([]string{
+		fmt.Sprintf(`servers.MediaServer: duplicate server: defined in "%s" and "%s"`, filepath.Join(tmp, "firewall.json"), filepath.Join(tmp, "servers", "MediaServer.toml")),
+		fmt.Sprintf(`servers.MyPC: duplicate server: defined in "%s" and "%s"`, filepath.Join(tmp, "servers", "MyPC.json"), filepath.Join(tmp, "servers", "MyPC.toml")),
+	}, "\n"))
+	// a root file is required
+	_, err = LoadFirewallDir(filepath.Join(tmp, "servers"))
+	unittest.NotNil(t, err)
+	// a backup isn't a root file
+	unittest.IsNil(t, os.Remove(filepath.Join(tmp, "firewall.json")))
+	_, err = LoadFirewallDir(tmp)
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), fmt.Sprintf(`%s: root file "firewall.<json|yaml|yml|toml>" not found`, tmp))
+}
@@ -5,6 +5,11 @@ import (
 	"net"
 )
 
+const (
+	FAMILY_IPV4 = 4
+	FAMILY_IPV6 = 6
+)
+
 type Network struct {
 	// Accessible IP
 	IP string `json:"ip,omitempty"`
@@ -34,39 +39,43 @@ type Network struct {
 }
 
 func (self *Network) IsValid() bool {
-	if self == nil {
-		log.Println("Network nil")
+	if err := self.Check(); err != nil {
+		log.Printf("Network invalid: %s\n", err)
 		return false
 	}
-	if self.IP == "" {
-		log.Println("Network.IP empty")
-		return false
+	return true
+}
+
+// Check returns a Firewall_Error for the first invalid attribute
+func (self *Network) Check() error {
+	return self.validate("").err()
+}
+func (self *Network) validate(
+	path string,
+) Firewall_Errors {
+	if self == nil {
+		return Firewall_Errors{newError(path, "Network nil")}
 	}
-	if net.ParseIP(self.IP) == nil {
-		log.Println("Network.IP invalid")
-		return false
+	var errs Firewall_Errors
+	if self.IP == "" {
+		errs = append(errs, newError(joinPath(path, "ip"), "empty"))
+	} else if net.ParseIP(self.IP) == nil {
+		errs = append(errs, newError(joinPath(path, "ip"), "\"%s\" invalid", self.IP))
 	}
 	// Hosts can be empty
-	for _, host := range self.Hosts {
+	for i, host := range self.Hosts {
 		// individual Hosts can not be empty
 		if host == "" {
-			log.Println("Network.Hosts host empty")
-			return false
+			errs = append(errs, newError(indexPath(joinPath(path, "hosts"), i), "host empty"))
 		}
 	}
 	// ServicesPassive can be empty
 	for servicename, service := range self.ServicesPassive {
-		if !service.IsValid() {
-			log.Printf("Network.ServicesPassive[%s] service invalid\n", servicename)
-			return false
-		}
+		errs = append(errs, service.validate(joinPath(path, "services-passive", servicename))...)
 	}
 	// ServicesAcquirable can be empty
 	for servicename, service := range self.ServicesAcquirable {
-		if !service.IsValid() {
-			log.Printf("Network.ServicesAcquirable[%s] service invalid\n", servicename)
-			return false
-		}
+		errs = append(errs, service.validate(joinPath(path, "services-acquirable", servicename))...)
 	}
 	// ServiceDependencies can be empty
 	// Service objects are optional, and their values are optional
@@ -85,5 +94,33 @@ func (self *Network) IsValid() bool {
 			}
 		}
 	}*/
-	return true
+	// FirewallRulesBefore can be empty
+	for i, rule := range self.FirewallRulesBefore {
+		errs = append(errs, rule.validate(indexPath(joinPath(path, "firewall-rules-before"), i))...)
+	}
+	// FirewallRulesAfter can be empty
+	for i, rule := range self.FirewallRulesAfter {
+		errs = append(errs, rule.validate(indexPath(joinPath(path, "firewall-rules-after"), i))...)
+	}
+	return errs
+}
+
+// Family returns FAMILY_IPV4 or FAMILY_IPV6, or 0 if our IP is invalid
+func (self *Network) Family() int {
+	if self == nil {
+		return 0
+	}
+	return ipFamily(self.IP)
+}
+func ipFamily(
+	ip string,
+) int {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return 0
+	}
+	if parsed.To4() != nil {
+		return FAMILY_IPV4
+	}
+	return FAMILY_IPV6
 }
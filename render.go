@@ -0,0 +1,84 @@
+package firewall
+
+const (
+	ARTIFACT_HOSTNAME = "hostname"
+	ARTIFACT_HOSTS    = "hosts"
+	ARTIFACT_SSH      = "ssh"
+	ARTIFACT_FIREWALL = "firewall"
+)
+
+// artifact_kinds are in the order they're rendered and written
+var artifact_kinds = []string{
+	ARTIFACT_HOSTNAME,
+	ARTIFACT_HOSTS,
+	ARTIFACT_SSH,
+	ARTIFACT_FIREWALL,
+}
+
+// Firewall_Artifacts are the rendered files of a server
+// [Kind][Filename]Contents
+// Kind is one of ARTIFACT_*, the folder the file is written to by Build
+type Firewall_Artifacts map[string]map[string][]byte
+
+// Render renders every file of a server in memory without touching the disk
+// any error is returned as a Firewall_Error
+func (self *Firewall) Render(
+	name string,
+) (Firewall_Artifacts, error) {
+	if err := self.Check(); err != nil {
+		return nil, err
+	}
+	server, ok := self.Servers[name]
+	if !ok {
+		return nil, newError(serverPath(name), "Server not found")
+	}
+	return self.renderServer(
+		name,
+		server,
+		self.Index(),
+	)
+}
+func (self *Firewall) renderServer(
+	name string,
+	server *Server,
+	index *Firewall_Index,
+) (Firewall_Artifacts, error) {
+	// check our firewall
+	if err := self.checkFirewall(
+		name,
+		server,
+		index,
+	); err != nil {
+		return nil, err
+	}
+	artifacts := make(Firewall_Artifacts)
+	artifacts[ARTIFACT_HOSTNAME] = self.renderHostname(
+		name,
+		server,
+	)
+	hosts, err := self.renderHosts(
+		name,
+		server,
+	)
+	if err != nil {
+		return nil, err
+	}
+	artifacts[ARTIFACT_HOSTS] = hosts
+	artifacts[ARTIFACT_SSH] = self.renderSSH(
+		name,
+		server,
+	)
+	fw := self.buildFirewall(
+		name,
+		server,
+		index,
+	)
+	files, err := self.renderFirewall(
+		fw,
+	)
+	if err != nil {
+		return nil, wrapError(serverPath(name), err)
+	}
+	artifacts[ARTIFACT_FIREWALL] = files
+	return artifacts, nil
+}
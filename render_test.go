@@ -0,0 +1,151 @@
+package firewall
+
+import (
+	"fmt"
+	"github.com/sabey/unittest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFirewallRender(t *testing.T) {
+	fmt.Println("TestFirewallRender")
+	fw := &Firewall{
+		FirewallType: FIREWALL_NFTABLES,
+		Servers: map[string]*Server{
+			"Web": &Server{
+				Hostname: "web",
+				Hosts: map[string][]string{
+					"10.0.0.1": []string{"gateway"},
+				},
+				SSH: map[string]*SSH{
+					"admin": &SSH{
+						Host: "10.0.0.2",
+					},
+				},
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.0.2",
+						ServicesPassive: map[string]*Service{
+							"http": &Service{
+								Port: 80,
+								FirewallRules: []*Firewall_Rule{
+									&Firewall_Rule{
+										Protocol: "tcp",
+										Ports:    "{{.Service.Port}}",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	artifacts, err := fw.Render("Web")
+	unittest.IsNil(t, err)
+	unittest.Equals(t, len(artifacts), 4)
+	unittest.Equals(t, string(artifacts[ARTIFACT_HOSTNAME]["Web.hostname"]), "web\n")
+	unittest.Equals(t, strings.Contains(string(artifacts[ARTIFACT_HOSTS]["Web.hosts"]), "10.0.0.1\t\tgateway\n"), true)
+	unittest.Equals(t, strings.HasPrefix(string(artifacts[ARTIFACT_SSH]["Web-admin.sh"]), "#!/bin/bash\n"), true)
+	unittest.Equals(t, strings.Contains(string(artifacts[ARTIFACT_FIREWALL]["Web.nft"]), "add rule inet filter input tcp dport 80 accept\n"), true)
+	// nothing is written to disk
+	_, err = os.Stat("firewall")
+	unittest.Equals(t, os.IsNotExist(err), true)
+
+	// unknown servers fail
+	_, err = fw.Render("missing")
+	unittest.NotNil(t, err)
+	unittest.Equals(t, err.Error(), "servers.missing: Server not found")
+}
+func TestFirewallRenderVars(t *testing.T) {
+	fmt.Println("TestFirewallRenderVars")
+	rule := func(text string) []*Firewall_Rule {
+		return []*Firewall_Rule{
+			&Firewall_Rule{
+				Rule: text,
+			},
+		}
+	}
+	fw := &Firewall{
+		FirewallType:        FIREWALL_IPTABLES,
+		FirewallRulesBefore: rule("# global: {{.Vars.level}} {{.Vars.global}}"),
+		Vars: map[string]interface{}{
+			"level":  "firewall",
+			"global": "default",
+		},
+		Servers: map[string]*Server{
+			"Web": &Server{
+				Hostname:            "web",
+				FirewallRulesBefore: rule("# server: {{.Vars.level}} {{.Vars.global}}"),
+				Vars: map[string]interface{}{
+					"level": "server",
+				},
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP:                  "10.0.4.1",
+						FirewallRulesBefore: rule("# network: {{.Vars.level}} {{.Vars.global}}"),
+						Vars: map[string]interface{}{
+							"level":  "network",
+							"global": "network",
+						},
+						ServicesPassive: map[string]*Service{
+							"ssh": &Service{
+								FirewallRules: rule("# passive: {{.Vars.level}} {{.Vars.global}}"),
+								Vars: map[string]interface{}{
+									"level": "service",
+								},
+							},
+						},
+						ServicesAcquirable: map[string]*Service{
+							"http": &Service{
+								Port:          80,
+								FirewallRules: rule("# acquirable: {{.Vars.level}} {{.Vars.global}}"),
+							},
+						},
+					},
+				},
+			},
+			"Client": &Server{
+				Hostname: "client",
+				Vars: map[string]interface{}{
+					"level": "client",
+				},
+				Networks: map[string]*Network{
+					"lan": &Network{
+						IP: "10.0.4.2",
+						ServiceDependencies: map[string]map[string]map[string]*Service{
+							"Web": map[string]map[string]*Service{
+								"lan": map[string]*Service{
+									"http": &Service{
+										FirewallRules: rule("# dependency: {{.Vars.level}} {{.Vars.global}}"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	// the most specific level wins
+	artifacts, err := fw.Render("Web")
+	unittest.IsNil(t, err)
+	iptables := string(artifacts[ARTIFACT_FIREWALL]["Web.iptables"])
+	for _, expected := range []string{
+		"# global: server default\n",
+		"# server: server default\n",
+		"# network: network network\n",
+		"# passive: service network\n",
+		// our destination is the server being built
+		"# acquirable: network network\n",
+	} {
+		unittest.Equals(t, strings.Contains(iptables, expected), true)
+	}
+	artifacts, err = fw.Render("Client")
+	unittest.IsNil(t, err)
+	unittest.Equals(t, strings.Contains(string(artifacts[ARTIFACT_FIREWALL]["Client.iptables"]), "# dependency: client default\n"), true)
+	// the levels themselves are untouched
+	unittest.Equals(t, fw.Vars["level"], "firewall")
+	unittest.Equals(t, len(fw.Servers["Web"].Vars), 1)
+}
@@ -0,0 +1,133 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+)
+
+const schema_draft = "http://json-schema.org/draft-07/schema#"
+
+// Schema returns a JSON Schema of v, which is a Firewall or Settings
+// the schema is generated from our structs and their json tags, the same as our strict decoding, see `Unmarshal`
+// every struct other than v is a definition and every pointer can be null
+func Schema(
+	v interface{},
+) ([]byte, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil ||
+		t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("Schema requires a struct: %T", v)
+	}
+	s := &schema{
+		root:        t,
+		definitions: make(map[string]map[string]interface{}),
+	}
+	root := s.object(t)
+	root["$schema"] = schema_draft
+	root["title"] = t.Name()
+	if len(s.definitions) > 0 {
+		root["definitions"] = s.definitions
+	}
+	bs, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(bs, '\n'), nil
+}
+
+type schema struct {
+	root reflect.Type
+	// [TypeName]Schema
+	definitions map[string]map[string]interface{}
+}
+
+// value returns the schema of a value of t
+func (self *schema) value(
+	t reflect.Type,
+) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return map[string]interface{}{
+			"anyOf": []interface{}{
+				self.value(t.Elem()),
+				map[string]interface{}{"type": "null"},
+			},
+		}
+	case reflect.Struct:
+		return self.reference(t)
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": self.value(t.Elem()),
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": self.value(t.Elem()),
+		}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s := map[string]interface{}{
+			"type":    "integer",
+			"minimum": 0,
+		}
+		if t.Bits() < 64 {
+			s["maximum"] = uint64(math.MaxUint64) >> (64 - t.Bits())
+		}
+		return s
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	}
+	// interface{} accepts anything
+	return map[string]interface{}{}
+}
+
+// reference returns a reference to the definition of a struct
+// the definition is created the first time it's referenced
+func (self *schema) reference(
+	t reflect.Type,
+) map[string]interface{} {
+	if t == self.root {
+		return map[string]interface{}{"$ref": "#"}
+	}
+	if _, ok := self.definitions[t.Name()]; !ok {
+		// reserve our name first, a struct may refer to itself
+		self.definitions[t.Name()] = nil
+		self.definitions[t.Name()] = self.object(t)
+	}
+	return map[string]interface{}{"$ref": fmt.Sprintf("#/definitions/%s", t.Name())}
+}
+
+// object returns the schema of a struct
+// unknown properties aren't allowed, the same as our decoding
+func (self *schema) object(
+	t reflect.Type,
+) map[string]interface{} {
+	fields := jsonFields(t)
+	names := []string{}
+	for name, _ := range fields {
+		names = append(names, name)
+	}
+	// sort our names so nested definitions are always created in the same order
+	sort.Strings(names)
+	properties := make(map[string]interface{}, len(fields))
+	for _, name := range names {
+		properties[name] = self.value(fields[name])
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,53 @@
+package firewall
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sabey/unittest"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	fmt.Println("TestSchema")
+	// our committed schemas must match our structs
+	// regenerate with: go run ./cmd/firewall schema -schema <firewall|settings> > schema/<firewall|settings>.schema.json
+	for file, v := range map[string]interface{}{
+		"schema/firewall.schema.json": &Firewall{},
+		"schema/settings.schema.json": &Settings{},
+	} {
+		expected, err := ioutil.ReadFile(file)
+		unittest.IsNil(t, err)
+		bs, err := Schema(v)
+		unittest.IsNil(t, err)
+		unittest.Equals(t, string(bs), string(expected))
+	}
+
+	bs, err := Schema(&Firewall{})
+	unittest.IsNil(t, err)
+	schema := map[string]interface{}{}
+	unittest.IsNil(t, json.Unmarshal(bs, &schema))
+	unittest.Equals(t, schema["title"], "Firewall")
+	unittest.Equals(t, schema["additionalProperties"], false)
+	definitions := schema["definitions"].(map[string]interface{})
+	for _, name := range []string{"Server", "Network", "Service", "SSH", "Firewall_Rule"} {
+		unittest.NotNil(t, definitions[name])
+	}
+	// unexported fields aren't part of our schema
+	rule := definitions["Firewall_Rule"].(map[string]interface{})["properties"].(map[string]interface{})
+	properties := []string{}
+	for property, _ := range rule {
+		properties = append(properties, property)
+	}
+	sort.Strings(properties)
+	unittest.Equals(t, strings.Join(properties, ","), "action,destination,direction,interface,params,ports,protocol,rule,snippet,source,state")
+	// [ServerName][NetworkName][ServiceName]*Service
+	dependencies := definitions["Network"].(map[string]interface{})["properties"].(map[string]interface{})["service-dependencies"].(map[string]interface{})
+	service := dependencies["additionalProperties"].(map[string]interface{})["additionalProperties"].(map[string]interface{})["additionalProperties"].(map[string]interface{})
+	unittest.Equals(t, service["anyOf"].([]interface{})[0].(map[string]interface{})["$ref"], "#/definitions/Service")
+
+	_, err = Schema("firewall")
+	unittest.NotNil(t, err)
+}
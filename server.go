@@ -45,88 +45,79 @@ type Server struct {
 }
 
 func (self *Server) IsValid() bool {
-	if self == nil {
-		log.Println("Server nil")
+	if err := self.Check(); err != nil {
+		log.Printf("Server invalid: %s\n", err)
 		return false
 	}
+	return true
+}
+
+// Check returns a Firewall_Error for the first invalid attribute
+func (self *Server) Check() error {
+	return self.validate("").err()
+}
+func (self *Server) validate(
+	path string,
+) Firewall_Errors {
+	if self == nil {
+		return Firewall_Errors{newError(path, "Server nil")}
+	}
+	var errs Firewall_Errors
 	if self.Hostname == "" {
-		log.Println("Server.Hostname empty")
-		return false
+		errs = append(errs, newError(joinPath(path, "hostname"), "empty"))
 	}
 	// Hosts can be empty
 	for ip, hosts := range self.Hosts {
 		// key must be non empty
 		if ip == "" {
-			log.Printf("Server.Hosts[]{%s} IP empty\n", hosts)
-			return false
-		}
-		if net.ParseIP(ip) == nil {
-			log.Printf("Server.Hosts[%s]{%s} IP invalid\n", ip, hosts)
-			return false
+			errs = append(errs, newError(joinPath(path, "hosts"), "{%s} IP empty", hosts))
+		} else if net.ParseIP(ip) == nil {
+			errs = append(errs, newError(joinPath(path, "hosts", ip), "{%s} IP invalid", hosts))
 		}
 		if len(hosts) == 0 {
-			log.Printf("Server.Hosts[%s]{%s} Hosts Empty\n", ip, hosts)
-			return false
+			errs = append(errs, newError(joinPath(path, "hosts", ip), "Hosts Empty"))
 		}
-		for _, host := range hosts {
+		for i, host := range hosts {
 			// individual Hosts can not be empty
 			if host == "" {
-				log.Printf("Server.Hosts[%s]{%s} host empty\n", ip, hosts)
-				return false
+				errs = append(errs, newError(indexPath(joinPath(path, "hosts", ip), i), "host empty"))
 			}
 		}
 	}
 	// HostsDependencies can be empty
 	for name, networks := range self.HostsDependencies {
 		if name == "" {
-			log.Println("Dependency.HostsDependencies[] name empty")
-			return false
+			errs = append(errs, newError(joinPath(path, "hosts-dependencies"), "name empty"))
 		}
 		if len(networks) == 0 {
-			log.Printf("Dependency.HostsDependencies[%s]{%s} networks empty\n", name, networks)
-			return false
+			errs = append(errs, newError(joinPath(path, "hosts-dependencies", name), "networks empty"))
 		}
-		for _, network := range networks {
+		for i, network := range networks {
 			if network == "" {
-				log.Printf("Dependency.HostsDependencies[%s] network empty\n", name)
-				return false
+				errs = append(errs, newError(indexPath(joinPath(path, "hosts-dependencies", name), i), "network empty"))
 			}
 		}
 	}
 	// ssh is optional
-	for _, ssh := range self.SSH {
-		if !ssh.IsValid() {
-			log.Println("Server.SSH ssh invalid")
-			return false
-		}
+	for name, ssh := range self.SSH {
+		errs = append(errs, ssh.validate(joinPath(path, "ssh", name))...)
 	}
 	// FirewallRulesBefore can be empty
-	for _, rule := range self.FirewallRulesBefore {
-		if !rule.IsValid() {
-			log.Println("Server.FirewallRulesBefore rule invalid")
-			return false
-		}
+	for i, rule := range self.FirewallRulesBefore {
+		errs = append(errs, rule.validate(indexPath(joinPath(path, "firewall-before"), i))...)
 	}
 	// FirewallRulesAfter can be empty
-	for _, rule := range self.FirewallRulesAfter {
-		if !rule.IsValid() {
-			log.Println("Server.FirewallRulesAfter rule invalid")
-			return false
-		}
+	for i, rule := range self.FirewallRulesAfter {
+		errs = append(errs, rule.validate(indexPath(joinPath(path, "firewall-after"), i))...)
 	}
 	if len(self.Networks) == 0 {
-		log.Println("Server.Networks empty")
-		return false
+		errs = append(errs, newError(joinPath(path, "networks"), "empty"))
 	}
 	for name, network := range self.Networks {
 		if name == "" {
-			log.Println("Server.Network[] name empty")
-			return false
-		}
-		if !network.IsValid() {
-			log.Printf("Server.Network[%s] network invalid\n", name)
-			return false
+			errs = append(errs, newError(joinPath(path, "networks"), "name empty"))
 		}
+		errs = append(errs, network.validate(joinPath(path, "networks", name))...)
 	}
-	return true
+	return errs
 }
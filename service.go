@@ -2,6 +2,8 @@ package firewall
 
 import (
 	"log"
+	"strconv"
+	"strings"
 )
 
 type Service struct {
@@ -12,20 +14,105 @@ type Service struct {
 }
 
 func (self *Service) IsValid() bool {
-	if self == nil {
-		log.Println("Service nil")
+	if err := self.Check(); err != nil {
+		log.Printf("Service invalid: %s\n", err)
 		return false
 	}
+	return true
+}
+
+// Check returns a Firewall_Error for the first invalid attribute
+func (self *Service) Check() error {
+	return self.validate("").err()
+}
+func (self *Service) validate(
+	path string,
+) Firewall_Errors {
+	if self == nil {
+		return Firewall_Errors{newError(path, "Service nil")}
+	}
+	var errs Firewall_Errors
 	// FirewallRules can't be empty
 	if len(self.FirewallRules) == 0 {
-		log.Println("Service.FirewallRules empty")
-		return false
+		errs = append(errs, newError(joinPath(path, "rules"), "empty"))
+	}
+	for i, rule := range self.FirewallRules {
+		errs = append(errs, rule.validate(indexPath(joinPath(path, "rules"), i))...)
 	}
-	for _, rule := range self.FirewallRules {
-		if !rule.IsValid() {
-			log.Println("Service.FirewallRules rule invalid")
-			return false
+	return errs
+}
+
+// a port range opened by a service
+type service_port struct {
+	from uint16
+	to   uint16
+	// empty if our protocol is unknown, ie: a raw rule, which overlaps every protocol
+	protocol string
+}
+
+// servicePorts returns the ports opened by service
+// templated fields are unknown until we build, so Service.Port is used in their place
+func servicePorts(
+	service *Service,
+) []*service_port {
+	if service == nil {
+		return nil
+	}
+	ports := []*service_port{}
+	fallback := func(protocol string) {
+		if service.Port > 0 {
+			ports = append(ports, &service_port{
+				from:     service.Port,
+				to:       service.Port,
+				protocol: protocol,
+			})
 		}
 	}
-	return true
+	for _, rule := range service.FirewallRules {
+		if rule == nil {
+			continue
+		}
+		if !rule.IsStructured() {
+			// raw rules and snippets
+			fallback("")
+			continue
+		}
+		direction := strings.ToLower(strings.TrimSpace(rule.Direction))
+		if direction != "" &&
+			direction != RULE_DIRECTION_IN &&
+			!strings.Contains(direction, "{{") {
+			continue
+		}
+		protocol := strings.ToLower(strings.TrimSpace(rule.Protocol))
+		if strings.Contains(protocol, "{{") {
+			protocol = ""
+		}
+		if rule.Ports == "" ||
+			strings.Contains(rule.Ports, "{{") {
+			fallback(protocol)
+			continue
+		}
+		for _, port := range splitList(rule.Ports) {
+			port, err := parsePortRange(port)
+			if err != nil {
+				// reported by our build
+				continue
+			}
+			ranges := strings.Split(port, "-")
+			from, _ := strconv.ParseUint(ranges[0], 10, 16)
+			to := from
+			if len(ranges) == 2 {
+				to, _ = strconv.ParseUint(ranges[1], 10, 16)
+			}
+			ports = append(ports, &service_port{
+				from:     uint16(from),
+				to:       uint16(to),
+				protocol: protocol,
+			})
+		}
+	}
+	if len(service.FirewallRules) == 0 {
+		fallback("")
+	}
+	return ports
 }
@@ -7,6 +7,8 @@ type Settings struct {
 	BuildRemoveFolderHosts    bool   `json:"build-remove-folder-hosts,omitempty"`
 	BuildRemoveFolderSSH      bool   `json:"build-remove-folder-ssh,omitempty"`
 	BuildRemoveFolderFirewall bool   `json:"build-remove-folder-firewall,omitempty"`
+	// number of servers rendered at the same time, 0 or 1 renders one server at a time
+	BuildConcurrency int `json:"build-concurrency,omitempty"`
 }
 
 func (self *Settings) IsValid() bool {
@@ -24,49 +24,57 @@ type SSH struct {
 }
 
 func (self *SSH) IsValid() bool {
-	if self == nil {
-		log.Println("SSH nil")
+	if err := self.Check(); err != nil {
+		log.Printf("SSH invalid: %s\n", err)
 		return false
 	}
+	return true
+}
+
+// Check returns a Firewall_Error for the first invalid attribute
+func (self *SSH) Check() error {
+	return self.validate("").err()
+}
+func (self *SSH) validate(
+	path string,
+) Firewall_Errors {
+	if self == nil {
+		return Firewall_Errors{newError(path, "SSH nil")}
+	}
+	var errs Firewall_Errors
 	// User is optional
 	if self.Host == "" {
-		log.Println("SSH.Host empty")
-		return false
+		errs = append(errs, newError(joinPath(path, "host"), "empty"))
 	}
 	// Port is optional
 	// Flag is optional
-	for _, flag := range self.Flags {
+	for i, flag := range self.Flags {
 		// flag must not be empty if it exists
 		if flag == "" {
-			log.Println("SSH.Flag empty")
-			return false
+			errs = append(errs, newError(indexPath(joinPath(path, "flags"), i), "empty"))
 		}
 	}
 	if self.Tunnel {
 		// Tunnel
 		if self.RemotePort < 1 {
-			log.Println("SSH.RemotePort < 1")
-			return false
+			errs = append(errs, newError(joinPath(path, "remote-port"), "< 1"))
 		}
 		if self.LocalPort < 1 {
-			log.Println("SSH.LocalPort < 1")
-			return false
+			errs = append(errs, newError(joinPath(path, "local-port"), "< 1"))
 		}
 		if !self.TunnelReverse {
 			// Regular Tunnel
 			if self.RemoteHost == "" {
-				log.Println("SSH.Tunnel.RemoteHost is empty")
-				return false
+				errs = append(errs, newError(joinPath(path, "remote-host"), "Tunnel.RemoteHost is empty"))
 			}
 			// LocalHost is optional
 		} else {
 			// Reverse Tunnel
 			if self.LocalHost == "" {
-				log.Println("SSH.TunnelReverse.LocalHost is empty")
-				return false
+				errs = append(errs, newError(joinPath(path, "local-host"), "TunnelReverse.LocalHost is empty"))
 			}
 			// RemoteHost is optional
 		}
 	}
-	return true
+	return errs
 }